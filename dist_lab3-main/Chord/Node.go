@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"crypto/sha1"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"math/big"
@@ -13,7 +12,10 @@ import (
 	"net/rpc"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,16 +25,32 @@ const Debugging = false
 const CheckErrorprint = false
 
 type Node struct {
-	Id          big.Int  //
-	Address     string   //ipadress:port
-	FingerTable []string //
-	Predecessor string   //The previous node on the identifier circle
-	Successors  []string //-r [1,32]
-	Bucket      map[string][]string
-	Flags       Flags
-	M2          big.Int
-	M           int
-	stopChan    chan struct{}
+	Id           big.Int  //
+	Address      string   //ipadress:port
+	FingerTable  []string //
+	Predecessor  string   //The previous node on the identifier circle
+	Successors   []string //-r [1,32]
+	Bucket       map[string][]string
+	Replicas     map[string][]string //Copies of files that a predecessor is the primary owner of
+	ReplicaOwner map[string]string   //Key -> address of the primary owner of the copies
+	Blobs        map[string]int      //Content hash -> number of file versions pointing at the content
+	Flags        Flags
+	M2           big.Int
+	M            int
+	stopChan     chan struct{}
+
+	encryptionKey []byte  //Key from --key, files are encrypted before they leave this node. Never sent to other nodes.
+	storage       Storage //Where the bucket, replicas and blobs are kept, chosen with --storage
+	dataDirectory string  //ring<ring ID>/node<ID> below --data-dir
+	ringID        string  //Random identifier of the ring, recorded in the data directory, see datadir.go
+	cache         *fileCache
+	latency       *latencies //Round trips to other nodes, used to choose the fingers, see latency.go
+
+	peerIDs map[string]big.Int //Address -> ID of the nodes this node knows, see peers.go
+
+	ringLock sync.RWMutex //Guards Predecessor, Successors and FingerTable, see state.go
+	fileLock sync.Mutex   //Guards Bucket, Replicas, ReplicaOwner, Blobs and the version lists
+	idLock   sync.Mutex   //Guards peerIDs
 }
 
 /*
@@ -40,12 +58,26 @@ Creates a new node and joins an if the ring exists it will join the ring, or if
 It will create a new ring
 */
 func createNode(flags Flags, createNewRing bool) {
+	n, err := startNode(flags, createNewRing)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return
+	}
+	n.InputLoop()
+}
+
+/*
+startNode sets up a node, starts its server, creates or joins the ring and starts the periodical functions.
+Returns the running node, or an error if its data directory, storage or port cannot be used.
+*/
+func startNode(flags Flags, createNewRing bool) (*Node, error) {
 	fmt.Printf("Node Started with ip %s, port %d\n", flags.IP, flags.Port)
 
-	n := Node{}
+	n := &Node{peerIDs: make(map[string]big.Int)}
 	n.Flags = flags //Set node flags
-	go n.server()   //start server
-	time.Sleep(200 * time.Millisecond)
+	if flags.KeyFile != "" {
+		n.encryptionKey, _ = readKeyFile(flags.KeyFile) //Checked in handelFlags
+	}
 
 	//How to set M on whether it's a new ring or a join
 	if !createNewRing { //If JOIN
@@ -56,17 +88,49 @@ func createNode(flags Flags, createNewRing bool) {
 	//set node adress
 	n.Address = flags.IP + ":" + strconv.Itoa(flags.Port)
 	n.M2 = *n.calculateM2()
-	//Calculate and set node ID based on adress
+	//Calculate and set node ID based on adress, or on -i to place the node on purpose
 	n.Id = *hashModulo(Hash(n.Address), n.M2)
+	if flags.UserID != "" {
+		n.Id = *hashModulo(userIDValue(flags.UserID), n.M2)
+	}
+	n.learnID(n.Address, n.Id)
 	n.Successors = make([]string, n.Flags.R) //The size of Successors is n.Flags.R
 	n.FingerTable = make([]string, n.M)
 	n.Bucket = make(map[string][]string)
+	n.Replicas = make(map[string][]string)
+	n.ReplicaOwner = make(map[string]string)
+	n.Blobs = make(map[string]int)
+	n.cache = newFileCache(int64(n.Flags.Cache) << 20)
+	n.latency = newLatencies()
 	n.stopChan = make(chan struct{})
 
+	if err := n.openDataDirectory(n.Flags.DataDir, n.Flags.ForceDataDir); err != nil {
+		return nil, fmt.Errorf("Could not use the data directory: %s", err)
+	}
+	storage, err := n.openStorage(n.Flags.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open the storage: %s", err)
+	}
+	n.storage = storage
+	restored := n.loadBucket() //Files left by an earlier run with the same ID
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", n.Flags.Port))
+	if err != nil {
+		return nil, fmt.Errorf("Could not listen on port %d: %s", n.Flags.Port, err)
+	}
+	go n.server(listener) //start server, after the node is set up so no request sees a half initialized node
+	time.Sleep(200 * time.Millisecond)
+
 	if createNewRing { //Create new Ring
 		n.create()
 	} else { //Join Ring
-		n.join(n.Flags.JA, n.Flags.JP) //ja = ip to join, jp = port to join.
+		if err := n.join(n.Flags.JA, n.Flags.JP); err != nil { //ja = ip to join, jp = port to join.
+			close(n.stopChan) //Closes the listener
+			return nil, err
+		}
+		if restored {
+			go n.handBackKeys(n.Flags.Ts) //Give back the keys that other nodes are responsible for now
+		}
 	}
 
 	n.PrintDetails()
@@ -75,8 +139,11 @@ func createNode(flags Flags, createNewRing bool) {
 	go n.fix_fingers(n.Flags.Tff)
 	go n.check_predecessor(n.Flags.Tcp) // Check predecessor with interval Tcp
 	go n.stabilize(n.Flags.Ts)          // Stabilize the ring with interval Ts
+	go n.scrub(n.Flags.Tsc)             // Check the stored contents with interval Tsc
+	go n.collectExpired(n.Flags.Tex)    // Delete expired files with interval Tex
+	go n.antiEntropy(n.Flags.Tae)       // Compare the copies on the successors with interval Tae
 
-	n.InputLoop()
+	return n, nil
 }
 
 /*
@@ -94,13 +161,18 @@ func (n *Node) InputLoop() {
 	for {
 		fmt.Print("Give a command: \n")
 		scanner.Scan()
-		command := scanner.Text()
+		//The command can be followed by its arguments on the same line, e.g. "GetFile hej.txt out.txt"
+		fields := strings.Fields(scanner.Text())
+		command := ""
+		if len(fields) > 0 {
+			command = fields[0]
+		}
+		args := fields[min(1, len(fields)):]
 
 		switch command {
 
 		case "Closepred":
-			scanner.Scan()
-			ID := scanner.Text()
+			ID := argOrPrompt(scanner, args, 0, "Closepred: Give an ID:")
 			IDBigInt := new(big.Int)
 			IDBigInt, _ = IDBigInt.SetString(ID, 10)
 
@@ -109,15 +181,84 @@ func (n *Node) InputLoop() {
 			fmt.Printf("The address returned is: %s\n", addressGiven)
 
 		case "Lookup":
-			fmt.Print("Lookup: Give a filename: ")
-			scanner.Scan()
-			fileId, fileHost := n.Lookup(scanner.Text())
+			fileName := argOrPrompt(scanner, args, 0, "Lookup: Give a filename: ")
+			mode := n.Flags.Lookup //Optional, only on the same line: Lookup <name> <iterative|recursive>
+			if len(args) > 1 {
+				mode = args[1]
+			}
+			if !contains(LookupModes, mode) {
+				fmt.Printf("Lookup failed: unknown lookup mode %s, use one of %v\n", mode, LookupModes)
+				continue
+			}
+			fileId, fileHost := n.LookupWith(fileName, mode)
 			fmt.Printf("FIleID %s, stored at FileHost: %s\n", fileId.String(), fileHost)
 
+		case "Trace":
+			target := argOrPrompt(scanner, args, 0, "Trace: Give a filename or an ID:")
+			mode := "" //Optional, only on the same line: Trace <name|id> <iterative|recursive>
+			if len(args) > 1 {
+				mode = args[1]
+			}
+			n.Trace(target, mode)
+
 		case "StoreFile":
-			fmt.Println("StoreFile: Give file path:")
-			scanner.Scan()
-			n.StoreFile(scanner.Text())
+			filePath := argOrPrompt(scanner, args, 0, "StoreFile: Give file path:")
+			ttl, err := parseTTL(args, 1) //Optional, only on the same line: StoreFile <path> <ttl>
+			if err != nil {
+				fmt.Printf("StoreFile failed: %s\n", err)
+				continue
+			}
+			n.StoreFile(filePath, ttl)
+
+		case "GetFile":
+			fileName := argOrPrompt(scanner, args, 0, "GetFile: Give a filename (name@version for an older version):")
+			dest := argOrPrompt(scanner, args, 1, "GetFile: Give destination path (empty for current directory):")
+			n.GetFile(fileName, dest)
+
+		case "StoreDir":
+			n.StoreDir(argOrPrompt(scanner, args, 0, "StoreDir: Give directory path:"))
+
+		case "GetDir":
+			manifest := argOrPrompt(scanner, args, 0, "GetDir: Give a manifest name:")
+			dest := argOrPrompt(scanner, args, 1, "GetDir: Give destination directory (empty for the directory name):")
+			n.GetDir(manifest, dest)
+
+		case "Put":
+			key := argOrPrompt(scanner, args, 0, "Put: Give a key:")
+			value := strings.Join(args[min(1, len(args)):], " ") //The value is the rest of the line
+			if len(args) < 2 {
+				value = argOrPrompt(scanner, nil, 0, "Put: Give a value:")
+			}
+			if err := n.Put(key, []byte(value)); err != nil {
+				fmt.Printf("Put failed: %s\n", err)
+			} else {
+				fmt.Printf("Stored %d bytes under %s\n", len(value), key)
+			}
+
+		case "Get":
+			key := argOrPrompt(scanner, args, 0, "Get: Give a key:")
+			value, err := n.Get(key)
+			if err != nil {
+				fmt.Printf("Get failed: %s\n", err)
+			} else {
+				fmt.Printf("%s = %s\n", key, value)
+			}
+
+		case "ListVersions":
+			n.ListVersions(argOrPrompt(scanner, args, 0, "ListVersions: Give a filename:"))
+
+		case "ListFiles":
+			n.ListFiles(argOrPrompt(scanner, args, 0, "ListFiles: Give a node address (empty for this node):"))
+
+		case "ListAll":
+			pattern := "" //Optional, only on the same line: ListAll <pattern>
+			if len(args) > 0 {
+				pattern = args[0]
+			}
+			n.ListAll(pattern)
+
+		case "DeleteFile":
+			n.DeleteFile(argOrPrompt(scanner, args, 0, "DeleteFile: Give a filename:"))
 
 		case "PrintState":
 			n.PrintDetails()
@@ -126,76 +267,247 @@ func (n *Node) InputLoop() {
 			fmt.Println("Program is exiting.")
 			n.Exit()
 		default:
-			fmt.Println("Invalid command. Use Lookup, StoreFile, or PrintState. Type 'Exit' to exit.")
+			fmt.Println("Invalid command. Use Lookup, Trace, StoreFile, GetFile, StoreDir, GetDir, Put, Get, ListVersions, ListFiles, ListAll, DeleteFile, or PrintState. Type 'Exit' to exit.")
 		}
 	}
 }
 
+/*
+argOrPrompt returns args[i] if the argument was given on the command line.
+Otherwise it prints prompt and reads the value from the next line of input.
+*/
+func argOrPrompt(scanner *bufio.Scanner, args []string, i int, prompt string) string {
+	if i < len(args) {
+		return args[i]
+	}
+	fmt.Println(prompt)
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
 /*
 Lookup takes a filename as input. Hashes it with SHA-1 and runs modulus with Ringsize to calculate an ID.
 The runs find on the chord ring to find the successor of that ID. That ID is responsible for storing the file.
 */
 func (n *Node) Lookup(fileName string) (big.Int, string) {
+	return n.LookupWith(fileName, n.Flags.Lookup)
+}
+
+/*
+LookupWith is Lookup with the lookup mode given per call, iterative or recursive (see lookup.go).
+Prints the number of hops and how long the lookup took, to compare the modes.
+*/
+func (n *Node) LookupWith(fileName string, mode string) (big.Int, string) {
 	FileID := hashModulo(Hash(fileName), n.M2)
 
-	found, suc := n.find(*FileID, n.Address, MaxSteps)
-	if found {
+	route, err := n.LookupRoute(*FileID, mode)
+	if err == nil {
 
-		fmt.Printf("FileId %s, (Should be) stored at node: %s,\n ", FileID.String(), suc)
-		//}
-		return *FileID, suc //Suc = address
+		fmt.Printf("FileId %s, (Should be) stored at node: %s, %s lookup, %d hops in %s\n", FileID.String(), route.Owner, route.Mode, len(route.Hops), route.Elapsed.Round(time.Microsecond))
+		return *FileID, route.Owner //Suc = address
 		//"The Chord client then outputs that node’s identifier, IP address, and port."
 	} else {
-		fmt.Printf("Max steps reached during Lookup\n")
+		fmt.Printf("Lookup failed: %s\n", err)
 	}
 
 	return *FileID, "No Suc Found During Lookup"
 }
 
 /*
-StoreFile, Takes a filepath. Reads the file from disk. Runs func Lookup on the filename. Sends the file
-to the resonsible node found by Lookup.
+StoreFile, Takes a filepath and a time to live, 0 to keep the file until it is deleted. Runs func Lookup on the
+filename. Streams the file from disk to the resonsible node found by Lookup, in chunks so large files never have
+to fit in memory. If the upload is interrupted, running StoreFile again continues where it stopped.
 */
-func (n *Node) StoreFile(filePath string) {
-
+func (n *Node) StoreFile(filePath string, ttl time.Duration) {
 	fileName := path.Base(filePath) //Using path.Base to get the filename separated from the path.
+	n.storeAs(filePath, fileName, ttl)
+}
+
+/*
+storeAs stores the file on filePath in the ring under fileName, it expires after ttl if that is not 0.
+Returns true if the responsible node has it.
+*/
+func (n *Node) storeAs(filePath string, fileName string, ttl time.Duration) bool {
+	if err := checkFileName(fileName); err != nil {
+		fmt.Printf("StoreFile failed: %s\n", err)
+		return false
+	}
+
 	FileID, fileOwner := n.Lookup(fileName)
 
-	content, readok := readFile(filePath)
+	if _, err := os.Stat(filePath); err != nil {
+		fmt.Printf("No such file on disk in store file\n")
+		return false
+	}
 
-	if readok {
-		// read the content from the file to a []byte
-		fileStruct := File{ID: FileID, FileName: fileName, Content: content}
-		//Send message
-		SenderArgsNotify := SendArgs{StoreFileRequest: true, File: fileStruct}
-		ReceiveArgs := ReceiveArgs{}
-		ok := n.call("Node.CallHandler", &SenderArgsNotify, &ReceiveArgs, fileOwner)
+	mimeType := detectFileMimeType(filePath) //Of the file itself, also when it is sent encrypted
 
-		if ok {
-			//fmt.Printf("Reply from %s : %s\n", fileOwner, ReceiveArgs.ReplyArgs)
-		} else {
-			fmt.Printf("Error during call in StoreFile\n")
+	uploadPath := filePath
+	if n.encryptionKey != nil {
+		encryptedPath, err := n.encryptForUpload(filePath, fileName)
+		if err != nil {
+			fmt.Printf("StoreFile failed: could not encrypt %s: %s\n", filePath, err)
+			return false
 		}
-	} else {
+		uploadPath = encryptedPath
+	}
+
+	hash, err := hashFile(localDisk, uploadPath)
+	if err != nil {
 		fmt.Printf("No such file on disk in store file\n")
+		return false
+	}
+
+	erasure := n.Flags.ECData > 0
+	if erasure {
+		//Send the fragments, and then the descriptor instead of the file
+		descriptorPath, err := n.storeErasureCoded(fileName, uploadPath, hash, ttl)
+		if uploadPath != filePath {
+			removeUpload(uploadPath)
+		}
+		if err != nil {
+			fmt.Printf("StoreFile failed: %s\n", err)
+			return false
+		}
+		uploadPath = descriptorPath
+		hash, err = hashFile(localDisk, uploadPath)
+		if CheckError(err, "hashFile in StoreFile") {
+			return false
+		}
 	}
+
+	chunk := Chunk{ID: FileID, FileName: fileName, Hash: hash, Uploader: n.uploaderID(), MimeType: mimeType, Erasure: erasure, Expires: expiresAt(ttl)}
+	if !n.sendFile(fileOwner, chunk, localDisk, uploadPath) {
+		fmt.Printf("Error during call in StoreFile\n")
+		return false
+	}
+	if uploadPath != filePath {
+		removeUpload(uploadPath) //The encrypted file, or the descriptor of an erasure coded one
+	}
+	return true
 }
 
-// Read the content of a file and return it with a success status
-func readFile(filePath string) ([]byte, bool) {
+/*
+GetFile, Takes a filename and a destination path. Runs func Lookup on the filename and downloads the
+file in chunks from the responsible node. The content is written to destPath, or to the filename in the current
+directory if destPath is empty. If destPath is a directory the file is written inside it. Directories in the path are
+created, and a filename that would point outside the directory, like ../x, is only saved to an explicit destination.
+The filename can end with @<version> to get an older version, otherwise the latest version is fetched.
+*/
+func (n *Node) GetFile(nameWithVersion string, destPath string) bool {
+
+	fileName, version := splitVersion(nameWithVersion)
+
+	if info, err := os.Stat(destPath); destPath == "" || (err == nil && info.IsDir()) {
+		//The filename becomes the path, it must stay below the current or the given directory
+		if !filepath.IsLocal(filepath.FromSlash(fileName)) {
+			fmt.Printf("GetFile failed: %s points outside the directory, give a destination file\n", fileName)
+			return false
+		}
+		destPath = filepath.Join(destPath, filepath.FromSlash(fileName))
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		fmt.Printf("GetFile failed: %s\n", err)
+		return false
+	}
+
+	var FileID big.Int
+	var fileOwner, cacher, lastHop string
+	if version == 0 {
+		FileID, fileOwner, cacher, lastHop = n.lookupCached(fileName)
+	} else {
+		FileID, fileOwner = n.Lookup(fileName)
+	}
+
+	downloadPath := destPath
+	if n.encryptionKey != nil {
+		downloadPath = destPath + ".enc"
+	}
+
+	source := fileOwner
+	size, err := int64(0), fmt.Errorf("no node found for %s", fileName)
+	if cacher != "" {
+		source = cacher
+		size, err = n.fetchFile(cacher, Chunk{ID: FileID, FileName: fileName, FromCache: true}, localDisk, downloadPath)
+		if err != nil { //Dropped from the cache since the lookup
+			localDisk.Remove(downloadPath + ".part")
+			FileID, fileOwner = n.Lookup(fileName)
+			source = fileOwner
+		}
+	}
+	if source == fileOwner && fileOwner != "" {
+		size, err = n.fetchFile(fileOwner, Chunk{ID: FileID, FileName: fileName, Version: version}, localDisk, downloadPath)
+	}
+	if err != nil {
+		fmt.Printf("GetFile failed: %s\n", err)
+		return false
+	}
+	if lastHop != "" && lastHop != fileOwner {
+		n.offerCache(lastHop, FileID, fileName, fileOwner)
+	}
 
-	file, err := os.Open(filePath)
-	defer file.Close()
-	if CheckError(err, "Readfile in StoreFile") {
-		return nil, false
+	if isErasureDescriptor(downloadPath) {
+		size, err = n.rebuildErasureCoded(downloadPath, fileName)
+		if err != nil {
+			os.Remove(downloadPath)
+			fmt.Printf("GetFile failed: %s\n", err)
+			return false
+		}
+	}
+
+	if n.encryptionKey != nil {
+		if !isEncrypted(downloadPath) {
+			fmt.Printf("%s was not stored encrypted, saving it as it is\n", nameWithVersion)
+			err = os.Rename(downloadPath, destPath)
+		} else {
+			err = decryptFile(downloadPath, destPath, n.encryptionKey)
+			if err == nil {
+				os.Remove(downloadPath)
+				size = fileSize(localDisk, destPath)
+			}
+		}
+		if err != nil {
+			fmt.Printf("GetFile failed: %s: %s\n", nameWithVersion, err)
+			return false
+		}
+	} else if isEncrypted(destPath) {
+		fmt.Printf("%s is encrypted, start the node with --key to decrypt it\n", nameWithVersion)
 	}
+	fmt.Printf("File %s (%d bytes) fetched from %s and saved as %s\n", nameWithVersion, size, source, destPath)
+	return true
+}
+
+/*
+DeleteFile, Takes a filename. Runs func Lookup on the filename and asks the responsible node to delete it.
+The responsible node also deletes the copies on its successors. The fragments of an erasure coded
+file are deleted too. Returns true if the file existed.
+*/
+func (n *Node) DeleteFile(fileName string) bool {
+
+	FileID, fileOwner := n.Lookup(fileName)
 
-	content, err := ioutil.ReadAll(file)
-	if CheckError(err, "ReadAll in StoreFile") {
-		return nil, false
+	fragments := make([]string, 0)
+	if !isFragmentName(fileName) {
+		SenderArgs := SendArgs{ListVersionsRequest: true, File: File{ID: FileID, FileName: fileName}}
+		ReceiveArgs := ReceiveArgs{}
+		if n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, fileOwner) && ReceiveArgs.Answer {
+			fragments = n.fragmentNames(fileName, ReceiveArgs.Versions)
+		}
+	}
+	for _, fragment := range fragments {
+		n.DeleteFile(fragment)
 	}
 
-	return content, true
+	SenderArgs := SendArgs{DeleteFileRequest: true, File: File{ID: FileID, FileName: fileName}}
+	ReceiveArgs := ReceiveArgs{}
+	ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, fileOwner)
+
+	if !ok {
+		fmt.Printf("Error during call in DeleteFile\n")
+		return false
+	}
+	fmt.Printf("DeleteFile: %s\n", ReceiveArgs.ReplyArgs)
+	return ReceiveArgs.Answer
 }
 
 /*
@@ -205,56 +517,48 @@ Then Closes down all the processes on the current node. And deletes the files fr
 
 func (n *Node) Exit() {
 
-	if hashModulo(Hash(n.Successors[0]), n.M2).Cmp(&n.Id) == 0 { //I´m the only one
+	successor := n.successor()
+	if successor == n.Address { //I´m the only one
 
-		n.deleteDirectory("bucket" + n.Id.String())
+		n.deleteDirectory(n.bucketDirectory())
+		n.deleteDirectory(n.replicaDirectory())
+		n.deleteDirectory(n.blobDirectory())
+		n.releaseDataDirectory()
 		println("No need to send the files, no other Node in ring: EXIT")
 		close(n.stopChan) //Closing down all threads.
 		time.Sleep(1 * time.Second)
 		os.Exit(1)
 	} else {
 
-		Filebucket := make(map[string][]File)
-
-		for key, fileNames := range n.Bucket {
+		for key, fileNames := range n.bucketFiles() {
 			//Loop for all the files in every katalog
 			for _, fileName := range fileNames {
-				filepath := fmt.Sprintf("%s/%s/%s", "bucket"+n.Id.String(), key, fileName)
-				content, readok := readFile(filepath)
-
-				if readok {
-					Key := new(big.Int)
-					Key.SetString(key, 10)
-
-					file := File{ID: *Key, FileName: fileName, Content: content}
-					Filebucket[key] = append(Filebucket[key], file)
-				} else {
-					fmt.Printf("No such file on disk in exit\n")
+				if !n.sendVersions(successor, n.bucketDirectory(), key, fileName, false) {
+					println("Error during handover in Exit, the node keeps running")
+					return
 				}
 			}
+			n.fileLock.Lock()
 			delete(n.Bucket, key)
+			n.fileLock.Unlock()
 		}
 
-		SenderArgsNotify := SendArgs{PutAllRequest: true, SendBucket: Filebucket}
-		ReceiveArgs := ReceiveArgs{}
-		ok := n.call("Node.CallHandler", &SenderArgsNotify, &ReceiveArgs, n.Successors[0])
-		if ok {
-			if ReceiveArgs.Answer {
-				println("OK with Exit")
-				close(n.stopChan) //Closing down all threads.
-				n.deleteDirectory("bucket" + n.Id.String())
-				time.Sleep(1 * time.Second)
-				os.Exit(1)
-			}
-		} else {
-			println("Error during call in PutAll")
-		}
+		println("OK with Exit")
+		close(n.stopChan) //Closing down all threads.
+		n.deleteDirectory(n.bucketDirectory())
+		n.deleteDirectory(n.replicaDirectory())
+		n.deleteDirectory(n.partialDirectory())
+		n.deleteDirectory(n.blobDirectory())
+		os.RemoveAll(n.localPartialDirectory()) //Encrypted uploads, they are on the local disk whatever the storage is
+		n.releaseDataDirectory()
+		time.Sleep(1 * time.Second)
+		os.Exit(1)
 	}
 }
 
 /*
 GetM makes a call to an Node address and retreives the value M (2^M = ringsize)
-Adds the fetched M to the current nodes struct.
+Adds the fetched M, and the ID of the ring, to the current nodes struct.
 */
 func (n *Node) GetM(ja_ip string, jp_port int) {
 	calladdress := ja_ip + ":" + strconv.Itoa(jp_port)
@@ -265,6 +569,7 @@ func (n *Node) GetM(ja_ip string, jp_port int) {
 
 	if ok { //The call failed, Meaning the n.predecessor has Failed/Crashed
 		n.M = ReceiveArgs.ReplyInt
+		n.ringID = ReceiveArgs.RingID
 	} else {
 		println("Error during call in GetM")
 		os.Exit(1) //If we cannot get the M value we should abourt.
@@ -273,31 +578,30 @@ func (n *Node) GetM(ja_ip string, jp_port int) {
 }
 
 /*
-Creates an HTTP server listening on tcp on the port given by flag -p
+Serves the RPC calls of other nodes over HTTP on the listener, which listens on the port given by flag -p.
+Every node has its own RPC server, so several nodes can run in one process. The listener is closed when the node stops.
 */
-func (n *Node) server() {
-	rpc.Register(n)
-	rpc.HandleHTTP()
-	addr := fmt.Sprintf(":%d", n.Flags.Port)
-
-	l, e := net.Listen("tcp", addr)
-	if e != nil {
-		log.Fatal("listen error:", e)
+func (n *Node) server(l net.Listener) {
+	server := rpc.NewServer()
+	if err := server.Register(n); err != nil {
+		log.Fatal("register error:", err)
 	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
 	fmt.Printf("Listening on port %d...\n", n.Flags.Port)
 
-	select {
-	case <-n.stopChan:
+	go func() {
+		<-n.stopChan
 		fmt.Println("Stoping server")
 		l.Close()
-	default:
-
-		http.Serve(l, nil)
-	}
+	}()
+	http.Serve(l, mux)
 }
 
 // Create a new Chord ring with the currnet node as the only one in the ring
 func (n *Node) create() {
+	n.ringLock.Lock()
+	defer n.ringLock.Unlock()
 	//Set predecessor of the current node to its adress
 	n.Predecessor = n.Address
 	//Set first successor to its adress
@@ -309,10 +613,13 @@ func (n *Node) create() {
 // Join a chord ring containing node with address given by -ja & -jp
 // Runs find until it finds its successor on the chord ring.
 // Asks its successor if there is any files it should  be responsible for,
-// in that is the case, it retrives the files and stores them on disk
-func (n *Node) join(ja_ip string, jp_port int) {
+// in that is the case, it retrives the files and stores them on disk.
+// Returns an error if another node already has the ID of the current node, e.g. the same -i.
+func (n *Node) join(ja_ip string, jp_port int) error {
 
+	n.ringLock.Lock()
 	n.Predecessor = ""
+	n.ringLock.Unlock()
 
 	calladdress := ja_ip + ":" + strconv.Itoa(jp_port)
 
@@ -320,22 +627,30 @@ func (n *Node) join(ja_ip string, jp_port int) {
 
 	found, successor := n.find(n.Id, calladdress, MaxSteps)
 	if found {
+		if id := n.peerID(successor); successor != n.Address && id != nil && id.Cmp(&n.Id) == 0 {
+			return fmt.Errorf("Could not join: %s already has ID %s, give another -i", successor, n.Id.String())
+		}
+		n.ringLock.Lock()
 		n.Successors[0] = successor
 		n.FingerTable[0] = successor
+		n.ringLock.Unlock()
 
-		SenderArgsNotify := SendArgs{GetAllRequest: true, SendArg: n.Id}
+		//The successor streams the files to our address, they are added to our bucket as they arrive
+		SenderArgsNotify := SendArgs{GetAllRequest: true, SendArg: n.Id, SendArgString: n.Address}
 		ReceiveArgs := ReceiveArgs{}
-		ok := n.call("Node.CallHandler", &SenderArgsNotify, &ReceiveArgs, n.Successors[0]) //CALL OUR SUCCESSOR AND ASK FOR THE FILES WE SHOULD BE RESPONSIBLE FOR
+		ok := n.call("Node.CallHandler", &SenderArgsNotify, &ReceiveArgs, successor) //CALL OUR SUCCESSOR AND ASK FOR THE FILES WE SHOULD BE RESPONSIBLE FOR
 		if ok {
 			//fmt.Printf("%s sent a GetAllRequest and the call was ok \n", n.Id.String())
-
-			n.putAll(ReceiveArgs.SendBucket)
+			if ReceiveArgs.ReplyInt > 0 {
+				fmt.Printf("Received %d files from %s\n", ReceiveArgs.ReplyInt, successor)
+			}
 		} else {
 			println("Error during call FOR FILES in join")
 		}
 	} else {
 		fmt.Println("Join failed, MaxStep override")
 	}
+	return nil
 }
 
 // stabilize is called periodically. Verifies the nodes immediate successor and tells the successor about n.
@@ -365,18 +680,22 @@ func (n *Node) stabilize(ts int) {
 				fmt.Printf("\nstabilize\n")
 			}
 
+			//Work on a copy, the list is replaced at the end of the round. Lookups use the old list until then.
+			oldSuccessors := n.successorList()
+			successors := n.successorList()
+
 			SenderArgsPred := SendArgs{GetPredecessorRequest: true} //The argument to send to the node we are joining is the current nodes address.
 			ReceiveArgsPred := ReceiveArgs{}
 
-			ok := n.call("Node.CallHandler", &SenderArgsPred, &ReceiveArgsPred, n.Successors[0])
+			ok := n.timedCall("Node.CallHandler", &SenderArgsPred, &ReceiveArgsPred, successors[0])
 
 			if ok {
 
-				SuccID := hashModulo(Hash(n.Successors[0]), n.M2)
-				x := hashModulo(Hash(ReceiveArgsPred.ReplyArgs), n.M2)
+				SuccID := n.peerID(successors[0])
+				x := n.peerID(ReceiveArgsPred.ReplyArgs) //Sent along with the reply
 
-				if ReceiveArgsPred.ReplyArgs != "" && between(&n.Id, x, SuccID, false) { //If my successor's predecessor is located between me and my successor, it becomes my new successor.
-					n.Successors[0] = ReceiveArgsPred.ReplyArgs
+				if x != nil && SuccID != nil && between(&n.Id, x, SuccID, false) { //If my successor's predecessor is located between me and my successor, it becomes my new successor.
+					successors[0] = ReceiveArgsPred.ReplyArgs
 				}
 
 				//Getting the successor list from our (could be new) successor.
@@ -384,16 +703,16 @@ func (n *Node) stabilize(ts int) {
 				SenderArgsPred := SendArgs{GetSuccessorListRequest: true} //The argument to send to the node we are joining is the current nodes address.
 				ReceiveArgsPred := ReceiveArgs{}
 
-				ok = n.call("Node.CallHandler", &SenderArgsPred, &ReceiveArgsPred, n.Successors[0])
+				ok = n.timedCall("Node.CallHandler", &SenderArgsPred, &ReceiveArgsPred, successors[0])
 
 				if ok {
-					newSuccessors := make([]string, len(n.Successors))
+					newSuccessors := make([]string, len(successors))
 					//fmt.Println("We are updating our Successors list: the length of the successor list: ", len(newSuccessors))
-					copy(newSuccessors[1:], ReceiveArgsPred.SuccessorList[:len(n.Successors)-1]) //Copy with a shift of one position.
+					copy(newSuccessors[1:], ReceiveArgsPred.SuccessorList) //Copy with a shift of one position, the last entry falls off.
 
-					newSuccessors[0] = n.Successors[0] //The first position should be replaced by our successo
+					newSuccessors[0] = successors[0] //The first position should be replaced by our successo
 
-					n.Successors = newSuccessors //Updte the list
+					successors = newSuccessors //Updte the list
 				} else {
 					fmt.Printf("Error during call in stabilize\n")
 				}
@@ -404,26 +723,31 @@ func (n *Node) stabilize(ts int) {
 					fmt.Printf("Our successor is dead, the first call to check Pred failed, now we check if others in our Succlist is alive\n")
 				}
 
-				for i := 1; i < len(n.Successors); i++ {
-					if n.isNodeAlive(n.Successors[i]) {
-						n.Successors[0] = n.Successors[i]
+				for i := 1; i < len(successors); i++ {
+					if n.isNodeAlive(successors[i]) {
+						successors[0] = successors[i]
 
 						var x = 1
-						for y := i + 1; y < len(n.Successors); y++ {
-							n.Successors[x] = n.Successors[y]
-							n.Successors[y] = "" //Clear the position we have moved.
+						for y := i + 1; y < len(successors); y++ {
+							successors[x] = successors[y]
+							successors[y] = "" //Clear the position we have moved.
 							x++
 						}
 						break
 					}
 				}
 			}
+			n.setSuccessors(successors)
+
 			//Process to notify
-			SenderArgsNotify := SendArgs{Notify: true, SendArgString: n.Address} //The argument to send to the node we are joining is the current nodes address.
-			ok = n.call("Node.CallHandler", &SenderArgsNotify, nil, n.Successors[0])
+			SenderArgsNotify := SendArgs{Notify: true, SendArg: n.Id, SendArgString: n.Address} //The argument to send to the node we are joining is the current nodes address and ID.
+			ok = n.call("Node.CallHandler", &SenderArgsNotify, nil, successors[0])
 			if !ok {
 				fmt.Printf("Inside Stabilize: Error during Nofity call\n")
 			}
+
+			//Send copies of our files to the nodes that are new in the first k entries of the successor list
+			n.syncReplicas(oldSuccessors)
 		}
 	}
 }
@@ -452,24 +776,26 @@ func (n *Node) isNodeAlive(address string) bool {
 	return true
 }
 
-// The Node with address Var. address thinks it might be our predecessor. If the incoming address is between us and our old predecessor,
+// The Node with address Var. address and ID addressID thinks it might be our predecessor. If the incoming address is between us and our old predecessor,
 // or the current node doesn't have any precedecessor, we update our predecessor to the new address.
-func (n *Node) notify(address string) {
+func (n *Node) notify(address string, addressID big.Int) {
+	n.learnID(address, addressID)
+	n.ringLock.Lock()
+	defer n.ringLock.Unlock()
 
 	if n.Predecessor == address { //If we already know the pred we don´t have to do anything.
 		return
 	}
 
-	addressID := hashModulo(Hash(address), n.M2)
-
-	var PredecessorAsBigInt big.Int
-
-	PredecessorAsBigInt = *hashModulo(Hash(n.Predecessor), n.M2)
+	PredecessorAsBigInt := n.knownID(n.Predecessor) //Learned when it notified us
 
 	// If Predecessor is not specified OR if both the address we receive is not equal to our current Predecessor AND if
 	//the address is between our previous predecessor and us, then the address becomes our new predecessor.
-	if n.Predecessor == "" || (address != n.Predecessor && between(&PredecessorAsBigInt, addressID, &n.Id, false)) {
-
+	if n.Predecessor == "" || PredecessorAsBigInt == nil || (address != n.Predecessor && between(PredecessorAsBigInt, &addressID, &n.Id, false)) {
+		if n.Predecessor == "" {
+			//The predecessor failed, and maybe the nodes before it too. Everything up to the new one is ours now.
+			go n.promoteReplicas("", &addressID)
+		}
 		n.Predecessor = address //Uppdate the predecessor with new address
 		//fmt.Printf("Updating my pred\n")
 	}
@@ -499,10 +825,10 @@ func (n *Node) fix_fingers(tff int) {
 			next++
 
 			fingerStart := n.jump(n.Id, next)
-			//find the successor for the current
+			//find the successor for the current, and the node closest in latency in the finger's interval
 			found, suc := n.find(*fingerStart, n.Address, MaxSteps)
 			if found {
-				n.FingerTable[next-1] = suc
+				n.setFinger(next-1, n.chooseFinger(next, suc))
 			} else {
 				fmt.Printf("Max steps reached\n")
 			}
@@ -527,18 +853,20 @@ func (n *Node) check_predecessor(tcp int) {
 				fmt.Printf("\nCheck_predecessor\n")
 			}
 
-			if n.Predecessor == "" {
+			predecessor := n.predecessor()
+			if predecessor == "" {
 				continue //Loop again and sleep
 			}
 
 			SenderArgs := SendArgs{CheckSucORPredFail: true}
 			ReceiveArgs := ReceiveArgs{}
-			ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, n.Predecessor)
+			ok := n.timedCall("Node.CallHandler", &SenderArgs, &ReceiveArgs, predecessor)
 
-			if !ok { //The call failed, Meaning the n.predecessor has Failed/Crashed
+			//The call failed, Meaning the n.predecessor has Failed/Crashed. Unless notify gave us a new one meanwhile.
+			if !ok && n.replacePredecessor(predecessor, "") {
 
 				fmt.Printf("The Predecessor seems to have Failed\n")
-				n.Predecessor = ""
+				n.promoteReplicas(predecessor, nil) //We are now responsible for the keys of the failed node
 			}
 			if Debugging {
 				fmt.Printf("Predecessor is ok\n")
@@ -598,6 +926,9 @@ func (n *Node) call(rpcname string, args interface{}, reply interface{}, adress
 
 	err = c.Call(rpcname, args, reply)
 	if err == nil {
+		if receiveArgs, ok := reply.(*ReceiveArgs); ok && receiveArgs != nil {
+			n.learnIDs(adress, receiveArgs)
+		}
 		return true
 	}
 	fmt.Println(err)
@@ -610,19 +941,34 @@ and starts appropriate appropriate function. Returns arguments to the caller.
 */
 
 func (n *Node) CallHandler(sendArgs *SendArgs, receiveArgs *ReceiveArgs) error {
+	id := n.Id
+	receiveArgs.NodeID = &id //Every reply tells the caller our ID, see peers.go
+	if err := n.checkRequest(sendArgs); err != nil {
+		receiveArgs.ReplyArgs = "refused: " + err.Error()
+		receiveArgs.Refused = true
+		receiveArgs.Answer = false
+		return nil
+	}
 
 	if sendArgs.GetSuccessorRequest { //When find() calls to find a succ
-		bool, address := n.findSuccessor(sendArgs.SendArg)
+		bool, address, via := n.findSuccessor(sendArgs.SendArg)
 		receiveArgs.FindSuccessorAnswer.IsSuccessor = bool
 		receiveArgs.FindSuccessorAnswer.Address = address
+		receiveArgs.FindSuccessorAnswer.Via = via
+		receiveArgs.FindSuccessorAnswer.ID = n.Id
+		receiveArgs.PeerIDs = n.knownIDs(address)
 		receiveArgs.Answer = true
+		if sendArgs.File.FileName != "" { //A lookup for GetFile, which asks for cached copies on the way
+			_, receiveArgs.Cached = n.cache.get(cacheName(sendArgs.File.ID.String(), sendArgs.File.FileName))
+		}
 
 	} else if sendArgs.GetPredecessorRequest { //When stabilize() calls to find pred
-		receiveArgs.ReplyArgs = n.Predecessor
+		receiveArgs.ReplyArgs = n.predecessor()
+		receiveArgs.PeerIDs = n.knownIDs(receiveArgs.ReplyArgs)
 		receiveArgs.Answer = true
 
 	} else if sendArgs.Notify { //When notify() calls
-		n.notify(sendArgs.SendArgString)
+		n.notify(sendArgs.SendArgString, sendArgs.SendArg)
 
 	} else if sendArgs.CheckSucORPredFail { //When check_predecessor() calls and alive in stabilize
 		receiveArgs.ReplyArgs = "all_good"
@@ -630,153 +976,323 @@ func (n *Node) CallHandler(sendArgs *SendArgs, receiveArgs *ReceiveArgs) error {
 
 	} else if sendArgs.Mrequest {
 		receiveArgs.ReplyInt = n.M
+		receiveArgs.RingID = n.ringID
 		receiveArgs.Answer = true
 	} else if sendArgs.StoreFileRequest {
 
-		n.putFile(sendArgs.File)
-		receiveArgs.ReplyArgs = "File Stored"
-		receiveArgs.Answer = true
+		err := n.putFile(sendArgs.File)
+		if err != nil {
+			receiveArgs.ReplyArgs = err.Error()
+			receiveArgs.Answer = false
+		} else {
+			receiveArgs.ReplyArgs = "File Stored"
+			receiveArgs.Answer = true
+		}
 	} else if sendArgs.GetSuccessorListRequest {
 
 		receiveArgs.Answer = true
-		receiveArgs.SuccessorList = n.Successors
-	} else if sendArgs.PutAllRequest {
-
-		n.putAll(sendArgs.SendBucket)
-		receiveArgs.Answer = true
+		receiveArgs.SuccessorList = n.successorList()
+		receiveArgs.PeerIDs = n.knownIDs(receiveArgs.SuccessorList...)
 	} else if sendArgs.GetAllRequest {
-		if len(n.Bucket) != 0 {
-			receiveArgs.SendBucket = n.getAll(&sendArgs.SendArg) //Get the ID (big.ing) from the sender, and find via getall func which files he should receive
-		}
+		receiveArgs.ReplyInt = n.getAll(&sendArgs.SendArg, sendArgs.SendArgString) //Get the ID (big.ing) from the sender, and find via getall func which files he should receive
+		receiveArgs.Answer = true
 	} else if sendArgs.GetIdentifier {
 		receiveArgs.ReplyArgs = n.Flags.UserID
+	} else if sendArgs.ChunkOffsetRequest {
+		receiveArgs.Offset = n.receivedOffset(sendArgs.Chunk)
+		receiveArgs.Answer = true
+	} else if sendArgs.StoreChunkRequest {
+		offset, err := n.storeChunk(sendArgs.Chunk, sendArgs.SendArgString)
+		receiveArgs.Offset = offset
+		if err != nil {
+			receiveArgs.ReplyArgs = err.Error()
+			receiveArgs.Answer = false
+		} else {
+			receiveArgs.Answer = true
+		}
+	} else if sendArgs.LinkBlobRequest {
+		receiveArgs.Answer = n.linkFile(sendArgs.Chunk, sendArgs.SendArgString) == nil
+	} else if sendArgs.ListVersionsRequest {
+		versions, err := n.listVersions(sendArgs.File.ID.String(), sendArgs.File.FileName)
+		if err != nil {
+			receiveArgs.ReplyArgs = err.Error()
+			receiveArgs.Answer = false
+		} else {
+			receiveArgs.Versions = versions
+			receiveArgs.Answer = true
+		}
+	} else if sendArgs.PutValueRequest {
+		err := n.putValue(sendArgs.File)
+		if err != nil {
+			receiveArgs.ReplyArgs = err.Error()
+			receiveArgs.Answer = false
+		} else {
+			receiveArgs.Answer = true
+		}
+	} else if sendArgs.GetValueRequest {
+		value, err := n.getValue(sendArgs.File)
+		if err != nil {
+			receiveArgs.ReplyArgs = err.Error()
+			receiveArgs.Answer = false
+		} else {
+			receiveArgs.File = File{ID: sendArgs.File.ID, FileName: sendArgs.File.FileName, Content: value, Checksum: checksum(value)}
+			receiveArgs.Answer = true
+		}
+	} else if sendArgs.ListFilesRequest {
+		receiveArgs.Files = n.listFiles()
+		receiveArgs.Answer = true
+	} else if sendArgs.GetChunkRequest {
+		if sendArgs.Chunk.ForCache && sendArgs.Chunk.Offset == 0 {
+			n.cache.addCacher(cacheName(sendArgs.Chunk.ID.String(), sendArgs.Chunk.FileName), sendArgs.SendArgString)
+		}
+		chunk, err := n.getChunk(sendArgs.Chunk)
+		if err != nil {
+			receiveArgs.ReplyArgs = err.Error()
+			receiveArgs.Answer = false
+		} else {
+			receiveArgs.Chunk = chunk
+			receiveArgs.Answer = true
+		}
+	} else if sendArgs.DeleteFileRequest {
+		key := sendArgs.File.ID.String()
+		if n.deleteFile(key, sendArgs.File.FileName) {
+			receiveArgs.ReplyArgs = fmt.Sprintf("%s deleted from node %s", sendArgs.File.FileName, n.Address)
+			receiveArgs.Answer = true
+		} else {
+			receiveArgs.ReplyArgs = fmt.Sprintf("no file named %s in the bucket of node %s (key %s)", sendArgs.File.FileName, n.Address, key)
+			receiveArgs.Answer = false
+		}
+	} else if sendArgs.DeleteReplicaRequest {
+		n.fileLock.Lock()
+		receiveArgs.Answer = n.deleteReplicaFile(sendArgs.File.ID.String(), sendArgs.File.FileName)
+		n.fileLock.Unlock()
+	} else if sendArgs.DropReplicasRequest {
+		n.fileLock.Lock()
+		dropped := n.dropReplicasOf(sendArgs.SendArgString)
+		n.fileLock.Unlock()
+		receiveArgs.ReplyArgs = fmt.Sprintf("%d keys of %s dropped by node %s", dropped, sendArgs.SendArgString, n.Address)
+		receiveArgs.Answer = true
+	} else if sendArgs.MerkleRequest {
+		receiveArgs.MerkleHashes, receiveArgs.MerkleEntries = n.answerMerkle(sendArgs.Merkle, sendArgs.SendArgString)
+		receiveArgs.Answer = true
+	} else if sendArgs.CacheFileRequest {
+		go n.cacheFile(sendArgs.File.ID, sendArgs.File.FileName, sendArgs.SendArgString)
+		receiveArgs.Answer = true
+	} else if sendArgs.InvalidateCacheRequest {
+		n.cache.invalidate(cacheName(sendArgs.File.ID.String(), sendArgs.File.FileName))
+		receiveArgs.Answer = true
+	} else if sendArgs.ReconcileRequest {
+		receiveArgs.Versions, receiveArgs.Answer = n.reconcileReplica(sendArgs.File.ID.String(), sendArgs.File.FileName, sendArgs.Versions)
+	} else if sendArgs.RecursiveLookupRequest {
+		n.routeRecursive(sendArgs.SendArg, sendArgs.Steps, receiveArgs)
 	}
 	return nil
 }
 
 /*
 Add the file to the node's bucket by associating the file with a key
-in the bucket and save file's data, and save the file content to the disk
+in the bucket and save file's data, and save the file content to the disk.
+The content is checked against the checksum computed by the sender first, a file that does not match is refused.
 */
-func (n *Node) putFile(file File) {
+func (n *Node) putFile(file File) error {
 	//Generate a key for the file based on its id
 	key := file.ID.String()
 
-	n.Bucket[key] = append(n.Bucket[key], file.FileName)
+	if file.Checksum != checksum(file.Content) {
+		return fmt.Errorf("content of %s does not match its checksum, it was not stored", file.FileName)
+	}
 
-	CheckError(n.saveToFile(key, file.FileName, file.Content), "Savefile")
+	err := n.saveToFile(key, file.FileName, file.Content, file.Uploader)
+	if CheckError(err, "Savefile") {
+		return err
+	}
+	n.addFile(key, file.FileName)
+	return nil
 }
 
 /*
-saveToFile creates directories for the files to be saved in on disk. Saves the file on the correct directory.
+addFile adds a file that has been saved in the bucket directory to the node's bucket,
+and sends a copy of it to the first k successors.
 */
+func (n *Node) addFile(key string, fileName string) {
+	n.fileLock.Lock()
+	if !contains(n.Bucket[key], fileName) {
+		n.Bucket[key] = append(n.Bucket[key], fileName)
+	}
+	n.removeReplica(key) //We are the primary owner of the key now
+	n.fileLock.Unlock()
+	n.invalidateCaches(key, fileName)
 
-func (n *Node) saveToFile(IdKey string, filename string, content []byte) error {
-	// Create or open file to write
-	BucketDirectory := "bucket" + n.Id.String()
-
-	// Create directory and its subdirectory if it doesn't exist yet
-	err := os.MkdirAll(BucketDirectory, os.ModePerm)
-	CheckError(err, "mkdirall bucketDirectory in Savetofile")
+	//Keep a copy on the first k successors
+	n.replicate(map[string][]string{key: {fileName}})
+}
 
-	KeyDirectory := fmt.Sprintf("%s/%s", BucketDirectory, IdKey)
+/*
+deleteFile removes a file from the node's bucket and from disk, and tells the first k successors
+to delete their copies. Returns false if the filename was not stored under the key.
+*/
+func (n *Node) deleteFile(key string, fileName string) bool {
+	n.fileLock.Lock()
+	found := n.forgetFiles(n.bucketDirectory(), n.Bucket, key, []string{fileName})
+	n.fileLock.Unlock()
+	if !found {
+		return false
+	}
+	n.fileDeleted(key, fileName)
+	return true
+}
 
-	err = os.MkdirAll(KeyDirectory, os.ModePerm)
-	CheckError(err, "mkdirall, KeyDirectory, in Savetofile")
+/*
+fileDeleted is called after a file has been removed from the bucket. Drops it from the caches and tells the
+first k successors to delete their copies.
+*/
+func (n *Node) fileDeleted(key string, fileName string) {
+	n.invalidateCaches(key, fileName)
 
-	filePath := fmt.Sprintf("%s/%s", KeyDirectory, filename)
+	Key := new(big.Int)
+	Key.SetString(key, 10)
+	for _, target := range n.replicaTargets(n.successorList()) {
+		SenderArgs := SendArgs{DeleteReplicaRequest: true, File: File{ID: *Key, FileName: fileName}}
+		ReceiveArgs := ReceiveArgs{}
+		if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, target) {
+			fmt.Printf("Error during call in deleteFile to %s\n", target)
+		}
+	}
+}
 
-	err = ioutil.WriteFile(filePath, content, 0700)
-	if CheckError(err, "write file") {
-		return err
+/*
+forgetFiles removes the given filenames under key from files (the bucket or the replicas) and deletes their versions,
+and the key directory when no filename is left under the key. Returns false if none of the filenames was there.
+The caller holds n.fileLock.
+*/
+func (n *Node) forgetFiles(BucketDirectory string, files map[string][]string, key string, fileNames []string) bool {
+	remaining := files[key]
+	removed := make([]string, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		var found bool
+		if remaining, found = removeName(remaining, fileName); found {
+			removed = append(removed, fileName)
+		}
 	}
-	if Debugging {
-		fmt.Printf("Innehållet har sparats i filen: %s\n", filename)
+	if len(removed) == 0 {
+		return false
 	}
-	return nil
+
+	if len(remaining) == 0 {
+		n.removeKeyDirectory(BucketDirectory, key, removed)
+		delete(files, key)
+	} else {
+		for _, fileName := range removed {
+			n.removeVersions(BucketDirectory, key, fileName)
+		}
+		files[key] = remaining
+	}
+	return true
 }
 
 /*
-putAll receives a map of key/value pairs, and adds all of its contents to the local bucket of key/value pairs
-When a node is about to go down in response to a quit command, call put_all on its successor,
-handing it the entire local bucket before shutting down.
+removeName returns the list without any occurrence of name, and true if name was in the list.
 */
-func (n *Node) putAll(received map[string][]File) {
-
-	for key, files := range received {
-		existingValues := n.Bucket[key]
+func removeName(names []string, name string) ([]string, bool) {
+	remaining := make([]string, 0, len(names))
+	found := false
+	for _, entry := range names {
+		if entry == name {
+			found = true
+		} else {
+			remaining = append(remaining, entry)
+		}
+	}
+	return remaining, found
+}
 
-		fileNames := make([]string, 0)
+/*
+saveToFile saves the content once in the blob directory and adds it as a new version of the filename in the key directory.
+*/
 
-		for _, file := range files {
-			fileNames = append(fileNames, file.FileName)
+func (n *Node) saveToFile(IdKey string, filename string, content []byte, uploader string) error {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
 
-			err := n.saveToFile(file.ID.String(), file.FileName, file.Content)
-			CheckError(err, "In YourFunctionName, during saveToFile")
+	hash := checksum(content)
+	if !n.hasBlob(hash) {
+		err := n.storage.WriteFile(n.blobPath(hash), content)
+		if CheckError(err, "write file") {
+			return err
 		}
+	}
 
-		existingValues = append(existingValues, fileNames...)
-		n.Bucket[key] = existingValues
+	_, err := n.addVersion(n.bucketDirectory(), IdKey, filename, Version{Hash: hash, Size: int64(len(content)), Uploader: uploader, MimeType: detectMimeType(filename, content)})
+	if CheckError(err, "addVersion in Savetofile") {
+		return err
+	}
+	if Debugging {
+		fmt.Printf("Innehållet har sparats i filen: %s\n", filename)
 	}
+	return nil
 }
 
 /*
-It receives an adress from our new predecessor.
+It receives an ID and an adress from our new predecessor.
 It then calculates which IDs are between our new predecessor and our old one.
-After that it extracts all of its files with those IDs that are between and
-sends them to the adress belonging to the new predecessor.
-Then it locally deletes the files that were sent
+After that it streams all of its files with those IDs that are between
+to the adress belonging to the new predecessor.
+Then it locally deletes the files that were sent. Returns the number of files sent.
 */
-func (n *Node) getAll(NewPredID *big.Int) map[string][]File {
-
-	Filebucket := make(map[string][]File)
-
-	OldPredID := new(big.Int)
-	if n.Predecessor != "" {
-		OldPredID = hashModulo(Hash(n.Predecessor), n.M2)
+func (n *Node) getAll(NewPredID *big.Int, address string) int {
 
-	} else {
+	OldPredID := n.peerID(n.predecessor()) //nil without a predecessor
+	if OldPredID == nil {
 		OldPredID = &n.Id
 	}
 
-	KeyBigInt := new(big.Int)
-
+	//Remove the keys from the local bucket first, so the copies the new owner sends back are kept as replicas.
+	handedOver := make(map[string][]string)
+	n.fileLock.Lock()
 	for key, fileNames := range n.Bucket {
-		KeyBigInt, _ := KeyBigInt.SetString(key, 10)
-
+		KeyBigInt, _ := new(big.Int).SetString(key, 10)
 		if between(OldPredID, KeyBigInt, NewPredID, true) {
-			if Debugging {
-				fmt.Printf("Is between\n")
-				fmt.Printf("Katalog: %s\n", key)
-			}
-
-			for _, fileName := range fileNames {
+			handedOver[key] = fileNames
+			delete(n.Bucket, key)
+		}
+	}
+	n.fileLock.Unlock()
 
-				filepath := fmt.Sprintf("%s/%s/%s", "bucket"+n.Id.String(), key, fileName)
-				content, readok := readFile(filepath)
-				if readok {
-					Key := new(big.Int)
-					Key.SetString(key, 10)
+	sent := 0
+	for key, fileNames := range handedOver {
+		if Debugging {
+			fmt.Printf("Is between\n")
+			fmt.Printf("Katalog: %s\n", key)
+		}
 
-					fmt.Printf("Appending Filestruct with ID %s, Filename %s \n", Key.String(), fileName)
+		allSent := true
+		for _, fileName := range fileNames {
+			fmt.Printf("Sending file with ID %s, Filename %s \n", key, fileName)
 
-					file := File{ID: *Key, FileName: fileName, Content: content}
-					Filebucket[key] = append(Filebucket[key], file)
-				} else {
-					fmt.Printf("No such file on disk in getAll\n")
-					continue
-				}
+			if n.sendVersions(address, n.bucketDirectory(), key, fileName, false) {
+				sent++
+			} else {
+				allSent = false
 			}
+		}
+
+		n.fileLock.Lock()
+		if allSent {
 			//Delete the files in that key directory.
-			n.deleteDirectory("bucket" + n.Id.String() + "/" + key)
-			delete(n.Bucket, key) //Remove the key from the local bucket. (OBS NOT FILE ON DISK, JUST THE BUCKET MAP)
+			n.removeKeyDirectory(n.bucketDirectory(), key, fileNames)
 		} else {
-			continue
+			fmt.Printf("Could not send all files with key %s, keeping them\n", key)
+			n.removeReplica(key)
+			for _, fileName := range fileNames {
+				if !contains(n.Bucket[key], fileName) {
+					n.Bucket[key] = append(n.Bucket[key], fileName)
+				}
+			}
 		}
-
+		n.fileLock.Unlock()
 	}
 
-	return Filebucket
+	return sent
 	// takes the address of a new node that is between you and your predecessor. It should gather all keys that belong to that new node
 	//(use your between function to determine this) into a new map, and it should
 	//also remove them from your bucket. You can loop through all the values in a map like this:
@@ -784,10 +1300,124 @@ func (n *Node) getAll(NewPredID *big.Int) map[string][]File {
 }
 
 /*
-Deletes a folder with all of its files
+loadBucket rebuilds the bucket from the bucket directory of the storage if a node with the same ID has run
+in the same ring with the same storage before. Every subdirectory is a key and every file in it a filename listing its versions.
+Copies of other nodes files from the earlier run are removed, their owners send new copies.
+Returns true if any file was found.
+*/
+func (n *Node) loadBucket() bool {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+
+	n.deleteDirectory(n.replicaDirectory())
+
+	BucketDirectory := n.bucketDirectory()
+	keys, err := n.storage.ReadDir(BucketDirectory)
+	if err != nil {
+		return false //No earlier run
+	}
+
+	count := 0
+	for _, key := range keys {
+		if _, isNumber := new(big.Int).SetString(key, 10); !isNumber {
+			continue
+		}
+
+		files, err := n.storage.ReadDir(BucketDirectory + "/" + key)
+		if CheckError(err, "ReadDir in loadBucket") {
+			continue
+		}
+		for _, element := range files {
+			fileName := unescapeName(element)
+			if element != escapeName(fileName) {
+				//Stored by an older version with another encoding of the name
+				err := n.storage.Rename(BucketDirectory+"/"+key+"/"+element, versionsPath(BucketDirectory, key, fileName))
+				if CheckError(err, "Rename in loadBucket") {
+					continue
+				}
+			}
+			versions, err := n.readVersions(BucketDirectory, key, fileName)
+			if CheckError(err, "readVersions in loadBucket") {
+				continue
+			}
+			kept := make([]Version, 0, len(versions))
+			for _, version := range versions {
+				if n.hasBlob(version.Hash) {
+					kept = append(kept, version)
+					n.Blobs[version.Hash]++
+				}
+			}
+			if len(kept) == 0 {
+				fmt.Printf("Content of %s is missing, skipping it\n", fileName)
+				continue
+			}
+			if len(kept) < len(versions) {
+				CheckError(n.writeVersions(BucketDirectory, key, fileName, kept), "writeVersions in loadBucket")
+			}
+			n.Bucket[key] = append(n.Bucket[key], fileName)
+			count++
+		}
+	}
+	n.collectBlobs() //Content that only the removed copies pointed at
+
+	if count > 0 {
+		fmt.Printf("Restored %d files in %d keys from %s\n", count, len(n.Bucket), BucketDirectory)
+	}
+	return count > 0
+}
+
+/*
+handBackKeys is started after a join when files were restored from disk. It waits until the node has a
+predecessor, which means its key range (predecessor, n] is known. Keys outside that range are sent to the node
+responsible for them and deleted locally.
+*/
+func (n *Node) handBackKeys(ts int) {
+	duration := time.Duration(ts) * time.Millisecond
+
+	predecessor := n.predecessor()
+	PredID := n.peerID(predecessor)
+	for PredID == nil {
+		select {
+		case <-n.stopChan:
+			return
+		case <-time.After(duration):
+		}
+		predecessor = n.predecessor()
+		PredID = n.peerID(predecessor)
+	}
+
+	for key, fileNames := range n.bucketFiles() {
+		KeyBigInt, _ := new(big.Int).SetString(key, 10)
+		if between(PredID, KeyBigInt, &n.Id, true) {
+			continue //Still ours
+		}
+
+		found, owner := n.find(*KeyBigInt, n.Address, MaxSteps)
+		if !found || owner == n.Address {
+			continue
+		}
+
+		allSent := true
+		for _, fileName := range fileNames {
+			if !n.sendVersions(owner, n.bucketDirectory(), key, fileName, false) {
+				fmt.Printf("Error during handBackKeys to %s\n", owner)
+				allSent = false
+			}
+		}
+		if allSent {
+			n.fileLock.Lock()
+			n.forgetFiles(n.bucketDirectory(), n.Bucket, key, fileNames) //Files stored under the key meanwhile are kept
+			n.fileLock.Unlock()
+			fmt.Printf("Handed back key %s to %s\n", key, owner)
+		}
+	}
+}
+
+/*
+Deletes a folder with all of its files from the storage
 */
 func (n *Node) deleteDirectory(folderPath string) {
-	err := os.RemoveAll(folderPath)
+	err := n.storage.RemoveAll(folderPath)
 	if CheckError(err, "In deleteDirectory") {
 		return
 	}
@@ -796,72 +1426,99 @@ func (n *Node) deleteDirectory(folderPath string) {
 
 /*
 Finds and returns the successor of a node if the node is between itself and its succsesor
-otherwise it returns the adress of the closest preceding node
+otherwise it returns the adress of the closest preceding node. Also returns where the address was found
+(ViaSuccessor, ViaFinger or ViaSelf), which Trace shows.
+A node is responsible for its own ID. closestPrecedingEntry can hand that node back for its ID, which can be
+given with -i, so it answers for it itself instead of passing the lookup on around the ring.
 */
-func (n *Node) findSuccessor(id big.Int) (bool, string) {
+func (n *Node) findSuccessor(id big.Int) (bool, string, string) {
+	if id.Cmp(&n.Id) == 0 {
+		return true, n.Address, ViaSelf
+	}
 
-	//hash the address of the first element in successor
-	sucId := hashModulo(Hash(n.Successors[0]), n.M2)
+	//the ID of the first element in successor, nil if it does not answer
+	successor := n.successor()
+	sucId := n.peerID(successor)
 
-	if between(&n.Id, &id, sucId, true) {
-		return true, n.Successors[0]
+	if sucId != nil && between(&n.Id, &id, sucId, true) {
+		return true, successor, ViaSuccessor
 	} else {
 		//If closestPrecedingNode is curId then we return true and the adress
-		closestAddress := n.closestPrecedingNode(id)
+		closestAddress, via := n.closestPrecedingEntry(id)
 		if closestAddress == n.Address {
-			return true, closestAddress
+			return true, closestAddress, via
 		} else {
 			//If it is another node then we return it and false
-			return false, closestAddress
+			return false, closestAddress, via
 		}
 	}
 }
 
 /*
 Returns the address of the closes preceding node. Checks the closest candidate form the fingertable
-and the successor table and returns the closest of the two of them.
+and the successor table and returns the closest of the two of them. The node returned is always between the
+current node and id, so every hop of a lookup gets closer to id however the fingers were chosen.
 */
 func (n *Node) closestPrecedingNode(id big.Int) string {
+	address, _ := n.closestPrecedingEntry(id)
+	return address
+}
+
+/*
+closestPrecedingEntry is closestPrecedingNode, it also returns whether the address is a finger (ViaFinger),
+an entry of the successor list (ViaSuccessor) or the current node (ViaSelf).
+*/
+func (n *Node) closestPrecedingEntry(id big.Int) (string, string) {
 	fingerTableChoice := ""
 	SuccTableChoice := ""
+	var SuccTableChoiceID *big.Int
+	fingerTable, successors := n.fingers(), n.successorList()
 
-	for i := len(n.FingerTable) - 1; i >= 0; i-- {
-		if n.FingerTable[i] != "" {
-			fingerTableValueHash := hashModulo(Hash(n.FingerTable[i]), n.M2)
+	//Every finger is compared, not only the highest one before id: fingers chosen by latency are anywhere in their
+	//interval, and a finger kept from before a join can be further from id than a lower one.
+	var fingerDistance *big.Int
+	for i := len(fingerTable) - 1; i >= 0; i-- {
+		if fingerTable[i] != "" {
+			fingerTableValueHash := n.peerID(fingerTable[i])
 
 			if fingerTableValueHash != nil && between(&n.Id, fingerTableValueHash, &id, false) {
-
-				fingerTableChoice = n.FingerTable[i]
-				break
+				distance := n.CalculateDistance(*fingerTableValueHash, id)
+				if fingerDistance == nil || distance.Cmp(fingerDistance) < 0 {
+					fingerTableChoice, fingerDistance = fingerTable[i], distance
+				}
 			}
 		}
 	}
 
-	for i := len(n.Successors) - 1; i >= 0; i-- {
-		if n.Successors[i] != "" {
-			SuccessorsTableValueHash := hashModulo(Hash(n.Successors[i]), n.M2)
+	for i := len(successors) - 1; i >= 0; i-- {
+		if successors[i] != "" {
+			SuccessorsTableValueHash := n.peerID(successors[i])
 			if SuccessorsTableValueHash != nil && between(&n.Id, SuccessorsTableValueHash, &id, true) { //Including the edge here for faster "Lookup"
-				SuccTableChoice = n.Successors[i]
+				SuccTableChoice, SuccTableChoiceID = successors[i], SuccessorsTableValueHash
 				break
 			}
 		}
 	}
 
 	if fingerTableChoice == "" && SuccTableChoice == "" {
-		return n.Address // If no nearby preceding node is found, return the current node.
+		return n.Address, ViaSelf // If no nearby preceding node is found, return the current node.
+	} else if SuccTableChoice == "" {
+		return fingerTableChoice, ViaFinger
+	} else if fingerTableChoice == "" {
+		return SuccTableChoice, ViaSuccessor
 	} else {
-		FingerDistance := n.CalculateDistance(*hashModulo(Hash(fingerTableChoice), n.M2), id)
-		SuccDistance := n.CalculateDistance(*hashModulo(Hash(SuccTableChoice), n.M2), id)
+		FingerDistance := fingerDistance
+		SuccDistance := n.CalculateDistance(*SuccTableChoiceID, id)
 
 		if FingerDistance.Cmp(big.NewInt(0)) == 0 {
-			return fingerTableChoice
+			return fingerTableChoice, ViaFinger
 		} else if SuccDistance.Cmp(big.NewInt(0)) == 0 {
-			return SuccTableChoice
+			return SuccTableChoice, ViaSuccessor
 		}
 		if FingerDistance.Cmp(SuccDistance) <= 0 {
-			return fingerTableChoice
+			return fingerTableChoice, ViaFinger
 		} else {
-			return SuccTableChoice
+			return SuccTableChoice, ViaSuccessor
 		}
 	}
 }
@@ -878,44 +1535,50 @@ func (n *Node) CalculateDistance(candidat, target big.Int) *big.Int {
 	}
 	if candidat.Cmp(&target) > 0 {
 		// If candidate is greater than the target on the ring
-		target.Add(&target, &n.M2) //Add the ring size to the target to maintaine the structure
-		distance.Sub(&target, &candidat)
+		distance.Add(&target, &n.M2) //Add the ring size to the target to maintaine the structure, target shares its digits with the caller's value
+		distance.Sub(distance, &candidat)
 	}
 	return distance
 }
 
 /*
 Finds and returns the successor of a given node by id, starting the search at node "start" and stops if maxSteps is reached.
-Runs iteratively until a valid successors is found
+The lookup is routed as chosen with --lookup, see lookup.go.
 */
 func (n *Node) find(id big.Int, start string, maxSteps int) (bool, string) {
-	found, nextNode := false, start
-	i := 0
+	address, _, err := n.lookup(id, start, maxSteps, n.Flags.Lookup)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return false, ""
+	}
+	return true, address
+}
 
-	SenderArgs := SendArgs{GetSuccessorRequest: true, SendArg: id} //The argument to send to the node we are joining is the current nodes address.
-	ReceiveArgs := ReceiveArgs{}
+/*
+findIterative runs a lookup from the current node. It calls every hop itself, beginning with start, until a node
+answers that its successor is responsible for id. Returns that node and the hops.
+*/
+func (n *Node) findIterative(id big.Int, start string, maxSteps int) (string, []Hop, error) {
+	nextNode := start
+	path := make([]Hop, 0)
 
-	for !found && i < maxSteps {
-		ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, nextNode)
-		if ok {
-			found = ReceiveArgs.FindSuccessorAnswer.IsSuccessor
-			nextNode = ReceiveArgs.FindSuccessorAnswer.Address
-			i++
+	SenderArgs := SendArgs{GetSuccessorRequest: true, SendArg: id} //The argument to send to the node we are joining is the current nodes address.
 
-		} else {
-			fmt.Printf("Error during call in find\n")
-			return false, ""
+	for i := 1; i <= maxSteps; i++ {
+		ReceiveArgs := ReceiveArgs{}
+		sent := time.Now()
+		if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, nextNode) {
+			n.forgetNode(nextNode)
+			return "", path, unreachableHop(nextNode)
+		}
+		answer := ReceiveArgs.FindSuccessorAnswer
+		path = append(path, Hop{Address: nextNode, ID: answer.ID, Answer: answer.Address, Via: answer.Via, RTT: time.Since(sent)})
+		nextNode = answer.Address
+		if answer.IsSuccessor {
+			return nextNode, path, nil
 		}
-
-	}
-	if found {
-		//fmt.Printf("Successor %s found for node %s \n", nextNode, start)
-		return true, nextNode
-	} else {
-		fmt.Println("Error: Node address not found.")
-		return false, ""
 	}
-
+	return "", path, maxStepsReached(maxSteps)
 }
 
 var two = big.NewInt(2)
@@ -961,23 +1624,25 @@ func (n *Node) GetIdentifier(Address string) string {
 Prints the all important details of a node including the finger table entries, successor list, predecessor and bucket content
 */
 func (n *Node) PrintDetails() {
+	fingerTable, predecessor, successors := n.fingers(), n.predecessor(), n.successorList()
+
 	fmt.Println("********-Node Details:-********")
 	fmt.Printf("Id: %s, Identifier: %s, Address: %s\n", n.Id.String(), n.Flags.UserID, n.Address)
-	fmt.Printf("Finger Table: Size: %d\n", len(n.FingerTable))
-	for i, entry := range n.FingerTable {
+	fmt.Printf("Finger Table: Size: %d\n", len(fingerTable))
+	for i, entry := range fingerTable {
 		if entry != "" {
-			fmt.Printf("  -Entry %d:(Id %s + %d) Identifier: %s, ID: %s, Address: %s\n", i, n.Id.String(), int(math.Pow(2, float64(i))), n.GetIdentifier(entry), hashModulo(Hash(entry), n.M2).String(), entry)
+			fmt.Printf("  -Entry %d:(Id %s + %d) Identifier: %s, ID: %s, Address: %s, RTT: %s\n", i, n.Id.String(), int(math.Pow(2, float64(i))), n.GetIdentifier(entry), idText(n.peerID(entry)), entry, n.rttText(entry))
 		}
 	}
-	if n.Predecessor != "" {
-		fmt.Printf("Predecessor: Identifier: %s, ID: %s, Address: %s\n", n.GetIdentifier(n.Predecessor), hashModulo(Hash(n.Predecessor), n.M2).String(), n.Predecessor)
+	if predecessor != "" {
+		fmt.Printf("Predecessor: Identifier: %s, ID: %s, Address: %s\n", n.GetIdentifier(predecessor), idText(n.peerID(predecessor)), predecessor)
 	} else {
 		fmt.Printf("Predecessor: Identifier: , ID: , Address: \n")
 	}
-	fmt.Printf("Successors: Size: %d\n", len(n.Successors))
-	for i, successor := range n.Successors {
+	fmt.Printf("Successors: Size: %d\n", len(successors))
+	for i, successor := range successors {
 		if successor != "" {
-			fmt.Printf("  -Entry %d: Identifier: %s, ID: %s, Address: %s\n", i, n.GetIdentifier(successor), hashModulo(Hash(successor), n.M2).String(), successor)
+			fmt.Printf("  -Entry %d: Identifier: %s, ID: %s, Address: %s\n", i, n.GetIdentifier(successor), idText(n.peerID(successor)), successor)
 		}
 	}
 	if Debugging {
@@ -987,10 +1652,17 @@ func (n *Node) PrintDetails() {
 		// Output for intent, M2 and M
 		fmt.Printf(" M2: %s, M: %d\n", n.M2.String(), n.M)
 	}
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
 	fmt.Println("Bucket:")
 	for key, value := range n.Bucket {
 		fmt.Printf("  Key: %s, Value: %s\n", key, value)
 	}
+	fmt.Printf("Stored contents: %d\n", len(n.Blobs))
+	fmt.Printf("Replicas: (k = %d)\n", n.Flags.K)
+	for key, value := range n.Replicas {
+		fmt.Printf("  Key: %s, Value: %s, Owner: %s\n", key, value, n.ReplicaOwner[key])
+	}
 
 	fmt.Println("********-END Node Details:-********")
 }
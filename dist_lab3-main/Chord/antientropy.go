@@ -0,0 +1,395 @@
+package Chord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+/*
+Anti-entropy keeps the copies on the successors equal to the bucket of their primary owner. Every round the
+node builds a Merkle tree over the files in its key range and compares it with the tree its replica targets
+build over the copies they hold of that range. Only subtrees whose hashes differ are descended into, and only
+the filenames in differing leaves are reconciled, so copies converge without resending whole buckets.
+
+The leaves split the identifier circle into 2^depth equal parts by the top bits of the key. A leaf hashes the
+key, filename and version list (number, content hash, expiry) of every file in it, an inner node hashes its
+two children.
+*/
+
+// The number of levels below the root, the tree has at most 2^merkleDepth leaves.
+const merkleDepth = 6
+
+// Which part of the ring a Merkle request is about, and which tree nodes are asked for.
+type MerkleQuery struct {
+	From    big.Int //The key range (From, To], the primary owner's predecessor and the primary owner
+	To      big.Int
+	Whole   bool  //The owner has no predecessor, use every copy it is the primary owner of
+	Level   int   //Level of the requested hashes, 0 is the root
+	Indices []int //Indices of the requested tree nodes on Level
+	Leaves  bool  //Return the entries of the leaves in Indices instead of hashes
+}
+
+// A filename in a Merkle leaf, Digest summarizes its versions.
+type MerkleEntry struct {
+	Key      string
+	FileName string
+	Digest   string
+}
+
+/*
+merkleDepthFor returns the depth of the tree, at most the number of bits of a key.
+*/
+func (n *Node) merkleDepthFor() int {
+	if n.M < merkleDepth {
+		return n.M
+	}
+	return merkleDepth
+}
+
+/*
+merkleLeaf returns the index of the leaf a key belongs to, the top depth bits of the key.
+*/
+func (n *Node) merkleLeaf(key string, depth int) int {
+	Key, ok := new(big.Int).SetString(key, 10)
+	if !ok {
+		return 0
+	}
+	return int(Key.Rsh(Key, uint(n.M-depth)).Int64())
+}
+
+/*
+versionDigest summarizes the versions of a filename, two nodes with the same digest hold the same versions.
+*/
+func versionDigest(versions []Version) string {
+	hasher := sha256.New()
+	for _, version := range versions {
+		fmt.Fprintf(hasher, "%d:%s:%d;", version.Number, version.Hash, version.Expires.Unix())
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+/*
+merkleEntries returns the entries of the files in BucketDirectory (from files, key -> filenames) that are in
+the range of query, grouped by leaf. If owner is set, Whole selects the keys whose copies owner is the primary of.
+The caller holds n.fileLock.
+*/
+func (n *Node) merkleEntries(BucketDirectory string, files map[string][]string, query MerkleQuery, owner string, depth int) map[int][]MerkleEntry {
+	leaves := make(map[int][]MerkleEntry)
+	for key, fileNames := range files {
+		if query.Whole {
+			if owner != "" && n.ReplicaOwner[key] != owner {
+				continue
+			}
+		} else {
+			Key, ok := new(big.Int).SetString(key, 10)
+			if !ok || !between(&query.From, Key, &query.To, true) {
+				continue
+			}
+		}
+		leaf := n.merkleLeaf(key, depth)
+		for _, fileName := range fileNames {
+			if isFragmentName(fileName) {
+				continue //Fragments are not copied
+			}
+			versions, err := n.readVersions(BucketDirectory, key, fileName)
+			if err != nil {
+				continue
+			}
+			leaves[leaf] = append(leaves[leaf], MerkleEntry{Key: key, FileName: fileName, Digest: versionDigest(versions)})
+		}
+	}
+	for _, entries := range leaves {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Key != entries[j].Key {
+				return entries[i].Key < entries[j].Key
+			}
+			return entries[i].FileName < entries[j].FileName
+		})
+	}
+	return leaves
+}
+
+/*
+merkleTree builds the tree over the leaves. tree[level][index] is the hash of a node, tree[0][0] the root
+and tree[depth] the leaves.
+*/
+func merkleTree(leaves map[int][]MerkleEntry, depth int) [][]string {
+	tree := make([][]string, depth+1)
+	tree[depth] = make([]string, 1<<depth)
+	for index := range tree[depth] {
+		hasher := sha256.New()
+		for _, entry := range leaves[index] {
+			fmt.Fprintf(hasher, "%s\x00%s\x00%s\n", entry.Key, entry.FileName, entry.Digest)
+		}
+		tree[depth][index] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	for level := depth - 1; level >= 0; level-- {
+		tree[level] = make([]string, 1<<level)
+		for index := range tree[level] {
+			sum := sha256.Sum256([]byte(tree[level+1][2*index] + tree[level+1][2*index+1]))
+			tree[level][index] = hex.EncodeToString(sum[:])
+		}
+	}
+	return tree
+}
+
+/*
+answerMerkle answers a Merkle request from the primary owner on address origin, over the copies this node holds.
+Returns the requested hashes, or the entries of the requested leaves.
+*/
+func (n *Node) answerMerkle(query MerkleQuery, origin string) ([]string, []MerkleEntry) {
+	depth := n.merkleDepthFor()
+	n.fileLock.Lock()
+	leaves := n.merkleEntries(n.replicaDirectory(), n.Replicas, query, origin, depth)
+	n.fileLock.Unlock()
+
+	if query.Leaves {
+		entries := make([]MerkleEntry, 0)
+		for _, index := range query.Indices {
+			entries = append(entries, leaves[index]...)
+		}
+		return nil, entries
+	}
+
+	tree := merkleTree(leaves, depth)
+	if query.Level < 0 || query.Level > depth {
+		return nil, nil
+	}
+	hashes := make([]string, len(query.Indices))
+	for i, index := range query.Indices {
+		if index >= 0 && index < len(tree[query.Level]) {
+			hashes[i] = tree[query.Level][index]
+		}
+	}
+	return hashes, nil
+}
+
+/*
+antiEntropy is called periodically. Compares the files in the node's range with the copies on each replica target.
+*/
+func (n *Node) antiEntropy(tae int) {
+	duration := time.Duration(tae) * time.Millisecond
+
+	for {
+		select {
+		case <-n.stopChan:
+			fmt.Println("Stopping anti-entropy")
+			return
+		default:
+			time.Sleep(duration)
+			if Debugging {
+				fmt.Printf("\nAnti-entropy\n")
+			}
+			for _, target := range n.replicaTargets(n.successorList()) {
+				if repaired := n.reconcileWith(target); repaired > 0 {
+					fmt.Printf("Anti-entropy: repaired %d files on %s\n", repaired, target)
+				}
+			}
+		}
+	}
+}
+
+/*
+reconcileWith compares the Merkle tree of the node's range with the tree of the copies on target, and reconciles
+every filename in the leaves that differ. Returns the number of filenames that were repaired on target.
+*/
+func (n *Node) reconcileWith(target string) int {
+	query := MerkleQuery{To: n.Id}
+	if predecessor := n.predecessor(); predecessor == "" || predecessor == n.Address {
+		query.Whole = true
+	} else if PredID := n.peerID(predecessor); PredID != nil {
+		query.From = *PredID
+	} else {
+		return 0 //The predecessor does not answer, check_predecessor clears it
+	}
+
+	depth := n.merkleDepthFor()
+	n.fileLock.Lock()
+	mine := n.merkleEntries(n.bucketDirectory(), n.Bucket, query, "", depth)
+	n.fileLock.Unlock()
+	tree := merkleTree(mine, depth)
+
+	//Descend from the root into the subtrees whose hashes differ
+	differing := []int{0}
+	for level := 0; level < depth && len(differing) > 0; level++ {
+		theirs, ok := n.askMerkle(target, query, level, differing, false)
+		if !ok {
+			return 0
+		}
+		next := make([]int, 0)
+		for i, index := range differing {
+			if theirs.hashes[i] != tree[level][index] {
+				next = append(next, 2*index, 2*index+1)
+			}
+		}
+		differing = next
+	}
+	if len(differing) == 0 {
+		return 0
+	}
+	theirs, ok := n.askMerkle(target, query, depth, differing, false)
+	if !ok {
+		return 0
+	}
+	leaves := make([]int, 0)
+	for i, index := range differing {
+		if theirs.hashes[i] != tree[depth][index] {
+			leaves = append(leaves, index)
+		}
+	}
+	if len(leaves) == 0 {
+		return 0
+	}
+
+	theirs, ok = n.askMerkle(target, query, depth, leaves, true)
+	if !ok {
+		return 0
+	}
+	type entryName struct{ key, fileName string }
+	remote := make(map[entryName]string)
+	for _, entry := range theirs.entries {
+		remote[entryName{entry.Key, entry.FileName}] = entry.Digest
+	}
+
+	repaired := 0
+	for _, index := range leaves {
+		for _, entry := range mine[index] {
+			name := entryName{entry.Key, entry.FileName}
+			digest, found := remote[name]
+			delete(remote, name)
+			if found && digest == entry.Digest {
+				continue
+			}
+			if n.reconcileFile(target, entry.Key, entry.FileName) {
+				repaired++
+			}
+		}
+	}
+	for name := range remote { //Copies of files the node does not have anymore
+		if n.reconcileFile(target, name.key, name.fileName) {
+			repaired++
+		}
+	}
+	return repaired
+}
+
+// The reply to a Merkle request.
+type merkleReply struct {
+	hashes  []string
+	entries []MerkleEntry
+}
+
+/*
+askMerkle sends a Merkle request for the given tree nodes to target.
+*/
+func (n *Node) askMerkle(target string, query MerkleQuery, level int, indices []int, leaves bool) (merkleReply, bool) {
+	query.Level, query.Indices, query.Leaves = level, indices, leaves
+	SenderArgs := SendArgs{MerkleRequest: true, Merkle: query, SendArgString: n.Address}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, target) {
+		fmt.Printf("Error during call in askMerkle to %s\n", target)
+		return merkleReply{}, false
+	}
+	if !leaves && len(ReceiveArgs.MerkleHashes) != len(indices) {
+		return merkleReply{}, false
+	}
+	return merkleReply{hashes: ReceiveArgs.MerkleHashes, entries: ReceiveArgs.MerkleEntries}, true
+}
+
+/*
+reconcileFile makes the copy of a filename on target equal to the versions in the bucket. The versions are sent
+to target, which removes the versions it should not have and answers with the versions it is missing. Only those
+are sent. A filename that is not in the bucket anymore is deleted on target.
+*/
+func (n *Node) reconcileFile(target string, key string, fileName string) bool {
+	versions := []Version{}
+	n.fileLock.Lock()
+	if contains(n.Bucket[key], fileName) {
+		read, err := n.readVersions(n.bucketDirectory(), key, fileName)
+		if CheckError(err, "readVersions in reconcileFile") {
+			n.fileLock.Unlock()
+			return false
+		}
+		versions = read
+	}
+	n.fileLock.Unlock()
+
+	Key := new(big.Int)
+	Key.SetString(key, 10)
+	SenderArgs := SendArgs{ReconcileRequest: true, File: File{ID: *Key, FileName: fileName}, Versions: versions}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, target) {
+		fmt.Printf("Error during call in reconcileFile to %s\n", target)
+		return false
+	}
+	if !ReceiveArgs.Answer {
+		return false
+	}
+
+	for _, version := range ReceiveArgs.Versions {
+		if !n.sendFile(target, versionChunk(*Key, fileName, version, true), n.storage, n.blobPath(version.Hash)) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+reconcileReplica is the receiving side of reconcileFile. Keeps the versions of the copy that are in wanted, with the
+expiry of wanted, and returns the versions of wanted it does not have. An empty wanted deletes the copy.
+Returns false if the key is in the node's own bucket, then it holds no copy of it.
+*/
+func (n *Node) reconcileReplica(key string, fileName string, wanted []Version) ([]Version, bool) {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+
+	if _, primary := n.Bucket[key]; primary {
+		return nil, false
+	}
+	if len(wanted) == 0 {
+		n.deleteReplicaFile(key, fileName)
+		return nil, true
+	}
+
+	have, err := n.readVersions(n.replicaDirectory(), key, fileName)
+	if err != nil {
+		return wanted, true //No copy yet, all versions are needed
+	}
+
+	wantedByNumber := make(map[int]Version)
+	for _, version := range wanted {
+		wantedByNumber[version.Number] = version
+	}
+	kept := make([]Version, 0, len(have))
+	removed := make([]Version, 0)
+	keptNumbers := make(map[int]bool)
+	for _, version := range have {
+		if want, ok := wantedByNumber[version.Number]; ok && want.Hash == version.Hash {
+			version.Expires = want.Expires
+			kept = append(kept, version)
+			keptNumbers[version.Number] = true
+		} else {
+			removed = append(removed, version)
+		}
+	}
+
+	if len(kept) == 0 {
+		n.deleteReplicaFile(key, fileName)
+	} else if !CheckError(n.writeVersions(n.replicaDirectory(), key, fileName, kept), "writeVersions in reconcileReplica") {
+		for _, version := range removed {
+			n.unrefBlob(version.Hash)
+		}
+	}
+
+	missing := make([]Version, 0)
+	for _, version := range wanted {
+		if !keptNumbers[version.Number] {
+			missing = append(missing, version)
+		}
+	}
+	return missing, true
+}
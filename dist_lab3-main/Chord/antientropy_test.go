@@ -0,0 +1,143 @@
+package Chord
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerkleLeaf(t *testing.T) {
+	tests := []struct {
+		m     int
+		key   string
+		depth int
+		leaf  int
+	}{
+		{16, "0", 6, 0},
+		{16, "1023", 6, 0}, //Every leaf covers 2^(16-6) keys
+		{16, "1024", 6, 1},
+		{16, "32768", 6, 32},
+		{16, "65535", 6, 63},
+		{3, "5", 3, 5}, //As many leaves as keys
+		{3, "7", 3, 7},
+		{16, "not a key", 6, 0},
+	}
+	for _, test := range tests {
+		n := &Node{M: test.m}
+		if depth := n.merkleDepthFor(); depth != min(test.m, merkleDepth) {
+			t.Errorf("m = %d: depth %d", test.m, depth)
+		}
+		if leaf := n.merkleLeaf(test.key, test.depth); leaf != test.leaf {
+			t.Errorf("m = %d: merkleLeaf(%s, %d) = %d, want %d", test.m, test.key, test.depth, leaf, test.leaf)
+		}
+	}
+}
+
+func TestMerkleTree(t *testing.T) {
+	const depth = 4
+	leaves := map[int][]MerkleEntry{
+		0:  {{Key: "1", FileName: "a", Digest: "x"}},
+		5:  {{Key: "20", FileName: "b", Digest: "y"}, {Key: "21", FileName: "c", Digest: "z"}},
+		15: {{Key: "63", FileName: "d", Digest: "w"}},
+	}
+	tree := merkleTree(leaves, depth)
+	if len(tree) != depth+1 {
+		t.Fatalf("%d levels, want %d", len(tree), depth+1)
+	}
+	for level := range tree {
+		if len(tree[level]) != 1<<level {
+			t.Errorf("level %d has %d nodes, want %d", level, len(tree[level]), 1<<level)
+		}
+	}
+	if !reflect.DeepEqual(merkleTree(leaves, depth), tree) {
+		t.Error("the same leaves gave another tree")
+	}
+
+	//A change in leaf 5 changes that leaf and its ancestors, and nothing else
+	changed := map[int][]MerkleEntry{0: leaves[0], 5: {leaves[5][0], {Key: "21", FileName: "c", Digest: "other"}}, 15: leaves[15]}
+	other := merkleTree(changed, depth)
+	for level := 0; level <= depth; level++ {
+		ancestor := 5 >> (depth - level)
+		for index := range tree[level] {
+			if differs := tree[level][index] != other[level][index]; differs != (index == ancestor) {
+				t.Errorf("level %d index %d: differs %t, the changed leaf is below index %d", level, index, differs, ancestor)
+			}
+		}
+	}
+
+	//Empty leaves hash alike, so two nodes without files agree
+	if merkleTree(nil, depth)[0][0] != merkleTree(map[int][]MerkleEntry{}, depth)[0][0] {
+		t.Error("two empty trees differ")
+	}
+	if merkleTree(nil, depth)[0][0] == tree[0][0] {
+		t.Error("an empty tree has the root of a full one")
+	}
+}
+
+func TestVersionDigest(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	base := []Version{{Number: 1, Hash: strings.Repeat("a", 64)}, {Number: 2, Hash: strings.Repeat("b", 64), Expires: expires}}
+	digest := versionDigest(base)
+	if versionDigest([]Version{base[0], base[1]}) != digest {
+		t.Error("the same versions gave another digest")
+	}
+	//The time of the upload and the uploader are not compared, only number, content and expiry
+	same := []Version{base[0], base[1]}
+	same[0].Time, same[0].Uploader = time.Now(), "someone"
+	if versionDigest(same) != digest {
+		t.Error("the upload time changed the digest")
+	}
+
+	for name, versions := range map[string][]Version{
+		"a version missing": base[:1],
+		"another number":    {base[0], {Number: 3, Hash: base[1].Hash, Expires: expires}},
+		"another content":   {base[0], {Number: 2, Hash: strings.Repeat("c", 64), Expires: expires}},
+		"another expiry":    {base[0], {Number: 2, Hash: base[1].Hash, Expires: expires.Add(time.Second)}},
+	} {
+		if versionDigest(versions) == digest {
+			t.Errorf("%s gave the same digest", name)
+		}
+	}
+}
+
+func TestMerkleEntries(t *testing.T) {
+	n := &Node{M: 16, storage: NewMemoryStorage(), ReplicaOwner: map[string]string{"100": "owner", "30000": "owner", "60000": "another"}}
+	files := map[string][]string{"100": {"b", "a", "a#fragment0"}, "30000": {"c"}, "60000": {"d"}, "65000": {"missing"}}
+	for key, fileNames := range files {
+		for _, fileName := range fileNames {
+			if fileName != "missing" {
+				n.writeVersions("replica", key, fileName, []Version{{Number: 1, Hash: strings.Repeat("a", 64)}})
+			}
+		}
+	}
+	names := func(leaves map[int][]MerkleEntry) string {
+		var listed []string
+		for leaf := 0; leaf < 1<<6; leaf++ {
+			for _, entry := range leaves[leaf] {
+				listed = append(listed, fmt.Sprintf("%d:%s/%s", leaf, entry.Key, entry.FileName))
+			}
+		}
+		return strings.Join(listed, " ")
+	}
+
+	tests := []struct {
+		name  string
+		query MerkleQuery
+		owner string
+		want  string
+	}{
+		{"range", MerkleQuery{From: *big.NewInt(50), To: *big.NewInt(30000)}, "", "0:100/a 0:100/b 29:30000/c"},
+		{"range ends at a key", MerkleQuery{From: *big.NewInt(100), To: *big.NewInt(60000)}, "", "29:30000/c 58:60000/d"},
+		{"range over zero", MerkleQuery{From: *big.NewInt(50000), To: *big.NewInt(200)}, "", "0:100/a 0:100/b 58:60000/d"},
+		{"whole ring of an owner", MerkleQuery{Whole: true}, "owner", "0:100/a 0:100/b 29:30000/c"},
+		{"whole ring", MerkleQuery{Whole: true}, "", "0:100/a 0:100/b 29:30000/c 58:60000/d"},
+	}
+	for _, test := range tests {
+		if got := names(n.merkleEntries("replica", files, test.query, test.owner, 6)); got != test.want {
+			t.Errorf("%s: %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package Chord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+/*
+File contents are stored once per node in blobs/<hash> of its data directory (ring<ring ID>/node<ID>, see
+datadir.go), where hash is the SHA-256 of the content. The name of a file in bucket/<key>/ or replica/<key>/ is
+a small file listing its versions and the hash of each version (see versions.go). n.Blobs counts the versions
+pointing at each blob, a blob is deleted when its last version is removed.
+*/
+
+/*
+blobDirectory is the directory where the node keeps the content of all its files.
+*/
+func (n *Node) blobDirectory() string {
+	return "blobs"
+}
+
+/*
+blobPath returns the path of the blob with the given content hash.
+*/
+func (n *Node) blobPath(hash string) string {
+	return fmt.Sprintf("%s/%s", n.blobDirectory(), hash)
+}
+
+/*
+hashFile calculates the SHA-256 of the stored name without reading it into memory. Returns it as hex.
+*/
+func hashFile(storage Storage, name string) (string, error) {
+	if _, err := storage.Size(name); err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, &storageReader{storage: storage, name: name}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+/*
+checksum returns the SHA-256 of content as hex, the same hash hashFile calculates for a file.
+*/
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+hasBlob returns true if the node already stores content with the given hash.
+*/
+func (n *Node) hasBlob(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	_, err := n.storage.Size(n.blobPath(hash))
+	return err == nil
+}
+
+/*
+storeBlob moves the stored name into the blob directory and returns its hash.
+If the node already has the same content the name is removed instead. The caller holds n.fileLock,
+so the blob is not released before a version points at it.
+*/
+func (n *Node) storeBlob(name string) (string, error) {
+	hash, err := hashFile(n.storage, name)
+	if err != nil {
+		return "", err
+	}
+
+	if n.hasBlob(hash) {
+		return hash, n.storage.Remove(name)
+	}
+	return hash, n.storage.Rename(name, n.blobPath(hash))
+}
+
+/*
+removeKeyDirectory releases the blobs of all versions of the given filenames and deletes the key directory.
+The caller holds n.fileLock.
+*/
+func (n *Node) removeKeyDirectory(BucketDirectory string, key string, fileNames []string) {
+	for _, fileName := range fileNames {
+		n.removeVersions(BucketDirectory, key, fileName)
+	}
+	n.deleteDirectory(BucketDirectory + "/" + key)
+}
+
+/*
+unrefBlob removes one reference to a blob. The blob is deleted from disk when no name points at it anymore.
+The caller holds n.fileLock.
+*/
+func (n *Node) unrefBlob(hash string) {
+	n.Blobs[hash]--
+	if n.Blobs[hash] > 0 {
+		return
+	}
+	delete(n.Blobs, hash)
+	CheckError(n.storage.Remove(n.blobPath(hash)), "Remove in unrefBlob")
+}
+
+/*
+collectBlobs deletes the blobs that no name points at, e.g. the content of copies removed at startup.
+The caller holds n.fileLock.
+*/
+func (n *Node) collectBlobs() {
+	blobs, err := n.storage.ReadDir(n.blobDirectory())
+	if err != nil {
+		return
+	}
+	for _, blob := range blobs {
+		if n.Blobs[blob] == 0 {
+			CheckError(n.storage.Remove(n.blobPath(blob)), "Remove in collectBlobs")
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package Chord
+
+import (
+	"container/list"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+/*
+Popular files are cached on the way to their owner. Every lookup for a file ends at the same node, the predecessor
+of the key, which answers that its successor has the file. GetFile asks every node on the lookup path whether it has
+the file cached and fetches it from the first one that has. Otherwise it fetches the file from the owner and asks the
+last node on the path to cache it, which then fetches the file from the owner itself.
+
+The owner remembers which nodes cache a file and tells them to drop it when a new version is stored, the file is
+deleted or a version expires. An entry is also dropped after CacheLifetime, in case the owner changed in between.
+Only the latest version is cached, GetFile name@version always goes to the owner.
+*/
+
+// How long a cached file is served at most.
+const CacheLifetime = 5 * time.Minute
+
+// A cached file.
+type cacheEntry struct {
+	name    string //cacheName of the file
+	hash    string
+	content []byte
+	expires time.Time
+}
+
+// The cache of a node, and on the owner the nodes that cache its files. Safe for use by several RPC calls at once.
+type fileCache struct {
+	mutex       sync.Mutex
+	capacity    int64 //Bytes, 0 disables the cache
+	used        int64
+	entries     map[string]*list.Element
+	order       *list.List          //Most recently used first
+	generations map[string]int      //Bumped by every invalidation, an older fetch is not cached
+	cachers     map[string][]string //On the owner: nodes that cache the file
+}
+
+func newFileCache(capacity int64) *fileCache {
+	return &fileCache{
+		capacity:    capacity,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		generations: make(map[string]int),
+		cachers:     make(map[string][]string),
+	}
+}
+
+/*
+cacheName identifies a file in the cache.
+*/
+func cacheName(key string, fileName string) string {
+	return key + "/" + fileName
+}
+
+/*
+get returns the cached file, it counts as used.
+*/
+func (c *fileCache) get(name string) (cacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[name]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(element)
+	return *entry, true
+}
+
+/*
+put caches content under name, unless name was invalidated after generation was read or the content does not fit.
+The least recently used files are dropped to make room.
+*/
+func (c *fileCache) put(name string, hash string, content []byte, expires time.Time, generation int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	size := int64(len(content))
+	if c.generations[name] != generation || size > c.capacity/4 {
+		return false
+	}
+	if element, ok := c.entries[name]; ok {
+		c.remove(element)
+	}
+	for c.used+size > c.capacity && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+	c.entries[name] = c.order.PushFront(&cacheEntry{name: name, hash: hash, content: content, expires: expires})
+	c.used += size
+	return true
+}
+
+/*
+remove drops an entry, the caller holds the mutex.
+*/
+func (c *fileCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.name)
+	c.used -= int64(len(entry.content))
+}
+
+/*
+generation returns how often name has been invalidated, read before the file is fetched for the cache.
+*/
+func (c *fileCache) generation(name string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generations[name]
+}
+
+/*
+invalidate drops the cached file, and any fetch of it that is in progress.
+*/
+func (c *fileCache) invalidate(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generations[name]++
+	if element, ok := c.entries[name]; ok {
+		c.remove(element)
+	}
+}
+
+/*
+addCacher records on the owner that the node on address caches the file.
+*/
+func (c *fileCache) addCacher(name string, address string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !contains(c.cachers[name], address) {
+		c.cachers[name] = append(c.cachers[name], address)
+	}
+}
+
+/*
+takeCachers returns the nodes that cache the file and forgets them.
+*/
+func (c *fileCache) takeCachers(name string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cachers := c.cachers[name]
+	delete(c.cachers, name)
+	return cachers
+}
+
+/*
+lookupCached runs the lookup of fileName and asks every node on the path if it has the file cached.
+Returns the key, the owner, the first node that has the file cached (empty if none, then the owner is
+found) and the last node on the path before the owner, which is where the file should be cached.
+*/
+func (n *Node) lookupCached(fileName string) (big.Int, string, string, string) {
+	FileID := hashModulo(Hash(fileName), n.M2)
+
+	SenderArgs := SendArgs{GetSuccessorRequest: true, SendArg: *FileID, File: File{ID: *FileID, FileName: fileName}}
+	nextNode := n.Address
+	for i := 0; i < MaxSteps; i++ {
+		ReceiveArgs := ReceiveArgs{}
+		if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, nextNode) {
+			fmt.Printf("Error during call in lookupCached\n")
+			return *FileID, "", "", ""
+		}
+		if ReceiveArgs.Cached {
+			fmt.Printf("FileId %s, cached at node: %s\n", FileID.String(), nextNode)
+			return *FileID, "", nextNode, ""
+		}
+		if ReceiveArgs.FindSuccessorAnswer.IsSuccessor {
+			owner := ReceiveArgs.FindSuccessorAnswer.Address
+			fmt.Printf("FileId %s, (Should be) stored at node: %s\n", FileID.String(), owner)
+			return *FileID, owner, "", nextNode
+		}
+		nextNode = ReceiveArgs.FindSuccessorAnswer.Address
+	}
+	fmt.Printf("Max steps reached during Lookup\n")
+	return *FileID, "", "", ""
+}
+
+/*
+offerCache asks the node on address to cache the file, which it fetches from owner.
+*/
+func (n *Node) offerCache(address string, Key big.Int, fileName string, owner string) {
+	SenderArgs := SendArgs{CacheFileRequest: true, File: File{ID: Key, FileName: fileName}, SendArgString: owner}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address) && Debugging {
+		fmt.Printf("offerCache: could not reach %s\n", address)
+	}
+}
+
+/*
+cacheFile fetches the latest version of a file from its owner into the cache. Files larger than a quarter of the
+cache are not cached. The node looks up the owner itself, the file is only fetched from the node that is
+responsible for its key, not from any address the node that asked names.
+*/
+func (n *Node) cacheFile(Key big.Int, fileName string, owner string) {
+	name := cacheName(Key.String(), fileName)
+	if n.cache.capacity == 0 || owner == n.Address {
+		return
+	}
+	if _, cached := n.cache.get(name); cached {
+		return
+	}
+	if hashModulo(Hash(fileName), n.M2).Cmp(&Key) != 0 {
+		fmt.Printf("Not caching %s: key %s is not the key of the filename\n", fileName, Key.String())
+		return
+	}
+	if found, responsible := n.find(Key, n.Address, MaxSteps); !found || responsible != owner {
+		fmt.Printf("Not caching %s: %s is not the node responsible for it\n", fileName, owner)
+		return
+	}
+	generation := n.cache.generation(name)
+
+	SenderArgs := SendArgs{ListVersionsRequest: true, File: File{ID: Key, FileName: fileName}}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, owner) || !ReceiveArgs.Answer || len(ReceiveArgs.Versions) == 0 {
+		return
+	}
+	latest := ReceiveArgs.Versions[len(ReceiveArgs.Versions)-1]
+	if latest.Size > n.cache.capacity/4 {
+		return
+	}
+
+	memory := NewMemoryStorage()
+	_, err := n.fetchFile(owner, Chunk{ID: Key, FileName: fileName, ForCache: true}, memory, "cached")
+	if err != nil {
+		if Debugging {
+			fmt.Printf("cacheFile: %s\n", err)
+		}
+		return
+	}
+	content, err := memory.ReadFile("cached")
+	if CheckError(err, "ReadFile in cacheFile") {
+		return
+	}
+
+	expires := time.Now().Add(CacheLifetime)
+	if !latest.Expires.IsZero() && latest.Expires.Before(expires) {
+		expires = latest.Expires
+	}
+	if n.cache.put(name, checksum(content), content, expires, generation) && Debugging {
+		fmt.Printf("Cached %s (%d bytes) from %s\n", fileName, len(content), owner)
+	}
+}
+
+/*
+cachedChunk returns the chunk at chunk.Offset of a cached file.
+*/
+func (n *Node) cachedChunk(chunk Chunk) (Chunk, error) {
+	entry, ok := n.cache.get(cacheName(chunk.ID.String(), chunk.FileName))
+	if !ok {
+		return chunk, fmt.Errorf("%s is not cached on node %s", chunk.FileName, n.Address)
+	}
+	size := int64(len(entry.content))
+	start := min(chunk.Offset, size)
+	chunk.Hash = entry.hash
+	chunk.Size = size
+	chunk.Data = entry.content[start:min(start+ChunkSize, size)]
+	return chunk, nil
+}
+
+/*
+invalidateCaches is called on the owner when a file changes. Drops the file from the local cache and tells every
+node that caches it to drop it.
+*/
+func (n *Node) invalidateCaches(key string, fileName string) {
+	name := cacheName(key, fileName)
+	n.cache.invalidate(name)
+
+	Key := new(big.Int)
+	Key.SetString(key, 10)
+	for _, address := range n.cache.takeCachers(name) {
+		go func(address string) {
+			SenderArgs := SendArgs{InvalidateCacheRequest: true, File: File{ID: *Key, FileName: fileName}}
+			ReceiveArgs := ReceiveArgs{}
+			if !n.callTimeout("Node.CallHandler", &SenderArgs, &ReceiveArgs, address, CallTimeout) {
+				fmt.Printf("Could not tell %s to drop %s from its cache, it expires within %s\n", address, fileName, CacheLifetime)
+			}
+		}(address)
+	}
+}
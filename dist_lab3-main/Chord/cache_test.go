@@ -0,0 +1,84 @@
+package Chord
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+cachedOn returns the node in nodes that has the file cached, nil if none has.
+*/
+func cachedOn(nodes []*Node, key string, fileName string) *Node {
+	for _, n := range nodes {
+		if _, ok := n.cache.get(cacheName(key, fileName)); ok {
+			return n
+		}
+	}
+	return nil
+}
+
+func TestRingCache(t *testing.T) {
+	nodes := startRing(t, 3)
+	const fileName = "popular.txt"
+	Key := hashModulo(Hash(fileName), nodes[0].M2)
+	key := Key.String()
+	owner := ringOwner(nodes, Key)
+	var others []*Node
+	for _, n := range nodes {
+		if n != owner {
+			others = append(others, n)
+		}
+	}
+	requester := others[0]
+
+	directory := t.TempDir()
+	store := func(content string) {
+		t.Helper()
+		filePath := filepath.Join(directory, "source")
+		os.WriteFile(filePath, []byte(content), 0644)
+		if !requester.storeAs(filePath, fileName, 0) {
+			t.Fatalf("storing %q failed", content)
+		}
+	}
+	get := func(want string) {
+		t.Helper()
+		destPath := filepath.Join(directory, "fetched")
+		if !requester.GetFile(fileName, destPath) {
+			t.Fatal("GetFile failed")
+		}
+		if got, err := os.ReadFile(destPath); err != nil || string(got) != want {
+			t.Errorf("GetFile returned %q (%v), want %q", got, err, want)
+		}
+	}
+
+	//The first get fetches from the owner and lets the last node on the path cache the file
+	store("first version")
+	get("first version")
+	waitFor(t, "the file in a cache", func() bool { return cachedOn(others, key, fileName) != nil })
+	cacher := cachedOn(others, key, fileName)
+
+	//The second get is served from that cache
+	if _, _, cachedAt, _ := requester.lookupCached(fileName); cachedAt != cacher.Address {
+		t.Errorf("the lookup found the file cached at %q, it is cached at %s", cachedAt, cacher.Address)
+	}
+	get("first version")
+
+	//A new version makes the owner drop the file from the cache, and the new content comes back
+	store("second version")
+	waitFor(t, "the cached file dropped", func() bool { return cachedOn(others, key, fileName) == nil })
+
+	//A node only fills its cache from the node responsible for the key, not from a node of another ring with a forgery
+	rogue := startTestNode(t, nil)
+	forgery := filepath.Join(directory, "forgery")
+	os.WriteFile(forgery, []byte("forged"), 0644)
+	if !rogue.storeAs(forgery, fileName, 0) {
+		t.Fatal("storing the forgery failed")
+	}
+	cacher.cacheFile(*Key, fileName, rogue.Address)
+	if entry, ok := cacher.cache.get(cacheName(key, fileName)); ok {
+		t.Errorf("%q was cached from a node that is not the owner", entry.content)
+	}
+
+	get("second version")
+}
@@ -3,6 +3,8 @@ package Chord
 import (
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 )
 
@@ -17,9 +19,23 @@ type Flags struct {
 	R               int    //ValidInputOther[3]
 	UserID          string //ValidInputOther[4]
 	M               int    //ValidInputOther[5]
+	K               int    //ValidInputOther[6]
+	Versions        int    //ValidInputOther[7]
+	KeyFile         string //ValidInputOther[8]
+	Tsc             int    //ValidInputOther[9]
+	Storage         string //ValidInputOther[10]
+	EC              string //ValidInputOther[11], "k+m"
+	ECData          int    //k from EC, 0 when files are not erasure coded
+	ECParity        int    //m from EC
+	Tex             int    //ValidInputOther[12]
+	Tae             int    //ValidInputOther[13]
+	DataDir         string //ValidInputOther[14]
+	Cache           int    //ValidInputOther[15], MiB
+	Lookup          string //ValidInputOther[16], iterative or recursive
+	ForceDataDir    bool   //Take over a data directory last used on another address
 	ValidInputNew   [2]bool
 	ValidInputJoin  [2]bool
-	ValidInputOther [6]bool
+	ValidInputOther [17]bool
 }
 
 var flags Flags
@@ -62,8 +78,20 @@ func handelFlags(args []string) {
 	flag.IntVar(&flags.Tff, "tff", 0, "The time in milliseconds between invocations of 'fix fingers'. Range [1,60000]")
 	flag.IntVar(&flags.Tcp, "tcp", 0, "The time in milliseconds between invocations of 'check predecessor'. Range [1,60000]")
 	flag.IntVar(&flags.R, "r", 0, "Number of successors maintained by the Chord client. Range [1,32]")
-	flag.StringVar(&flags.UserID, "i", "", "The identifier (ID) assigned to the Chord client: string of 40 characters matching [0-9a-fA-F]")
+	flag.StringVar(&flags.UserID, "i", "", "The identifier (ID) assigned to the Chord client: string of 40 characters matching [0-9a-fA-F]. The node is placed on the ring at this value modulo 2^m instead of at the hash of its address")
 	flag.IntVar(&flags.M, "m", 0, "The size of the ring, must be give [1 - 20]")
+	flag.IntVar(&flags.K, "k", 2, "Number of successors that keep a copy of every stored file. Range [0,32], at most r")
+	flag.IntVar(&flags.Versions, "versions", 5, "Number of versions kept of every stored file. Range [1,100]")
+	flag.IntVar(&flags.Tsc, "tsc", 60000, "The time in milliseconds between rounds of the scrubber that checks stored files. Range [1,3600000]")
+	flag.StringVar(&flags.Storage, "storage", "disk", "Where the node keeps its files: disk (a directory per kind), memory (lost on exit) or file (one file store.db)")
+	flag.IntVar(&flags.Cache, "cache", 16, "MiB of memory for caching popular files on their lookup path, 0 to not cache. Range [0,4096]")
+	flag.StringVar(&flags.DataDir, "data-dir", defaultDataDir(), "Directory below which every node keeps its files, in node<ID>")
+	flag.BoolVar(&flags.ForceDataDir, "force-data-dir", false, "Start on a data directory that was last used by a node on another address")
+	flag.StringVar(&flags.EC, "ec", "", "Store files erasure coded as k data and m parity fragments, given as k+m (e.g. 4+2) instead of as copies")
+	flag.IntVar(&flags.Tex, "tex", 5000, "The time in milliseconds between rounds of the collector that deletes expired files. Range [1,3600000]")
+	flag.IntVar(&flags.Tae, "tae", 30000, "The time in milliseconds between anti-entropy rounds that compare the copies on the successors. Range [1,3600000]")
+	flag.StringVar(&flags.Lookup, "lookup", LookupIterative, "How lookups are routed: iterative (this node calls every hop) or recursive (every hop forwards the query)")
+	flag.StringVar(&flags.KeyFile, "key", "", "Key file (32 bytes or 64 hex characters). Files are encrypted with it before StoreFile and decrypted after GetFile")
 
 	// Parse flag from commandLine
 	flag.CommandLine.Parse(args)
@@ -160,7 +188,7 @@ func handelFlags(args []string) {
 
 	flags.ValidInputOther[4] = true //Since optional
 	if flags.UserID != "" {
-		match, err := regexp.MatchString("^[0-9a-zA-Z]{0,40}$", flags.UserID)
+		match, err := regexp.MatchString("^[0-9a-fA-F]{40}$", flags.UserID)
 		if CheckError(err, "Error during regexp check on UserID") {
 			return
 		}
@@ -169,7 +197,7 @@ func handelFlags(args []string) {
 			fmt.Printf("Match! Value: %s\n", flags.UserID)
 		} else {
 			// Om det inte är en match, generera ett felmeddelande
-			fmt.Printf("Error: 'UserID' value must be a string of up to 40 characters matching [0-9a-zA-Z]")
+			fmt.Println("Error: 'UserID' value must be a string of 40 characters matching [0-9a-fA-F]")
 			flags.ValidInputOther[4] = false
 			return
 		}
@@ -195,6 +223,131 @@ func handelFlags(args []string) {
 		flags.ValidInputOther[5] = false
 		fmt.Println("The M flag needs to be specified when creating a new ring")
 	}
+
+	//K flag OPTIONAL (number of copies)
+
+	if flags.K >= 0 && flags.K <= 32 {
+		if flags.K > flags.R {
+			fmt.Printf("'k' is larger than 'r', only %d copies can be kept\n", flags.R)
+			flags.K = flags.R
+		}
+		fmt.Printf("Number of copies: %d\n", flags.K)
+		flags.ValidInputOther[6] = true
+	} else {
+		fmt.Println("Error: 'k' value out of range. Range [0,32]")
+		flags.ValidInputOther[6] = false
+	}
+
+	//Versions flag OPTIONAL
+
+	if flags.Versions >= 1 && flags.Versions <= 100 {
+		fmt.Printf("Versions kept per file: %d\n", flags.Versions)
+		flags.ValidInputOther[7] = true
+	} else {
+		fmt.Println("Error: 'versions' value out of range. Range [1,100]")
+		flags.ValidInputOther[7] = false
+	}
+
+	//Key flag OPTIONAL
+
+	flags.ValidInputOther[8] = true //Since optional
+	if flags.KeyFile != "" {
+		if _, err := readKeyFile(flags.KeyFile); err != nil {
+			fmt.Printf("Error: could not read key file: %s\n", err)
+			fmt.Println("A key can be created with: head -c 32 /dev/urandom > chord.key")
+			flags.ValidInputOther[8] = false
+		} else {
+			fmt.Printf("Files are encrypted with the key in %s\n", flags.KeyFile)
+		}
+	}
+
+	//TSC-flag OPTIONAL
+
+	if flags.Tsc >= 1 && flags.Tsc <= 3600000 {
+		fmt.Printf("Time between scrubber rounds: %d\n", flags.Tsc)
+		flags.ValidInputOther[9] = true
+	} else {
+		fmt.Println("Error: 'tsc' value out of range. Range [1,3600000]")
+		flags.ValidInputOther[9] = false
+	}
+
+	//STORAGE-flag OPTIONAL
+
+	if contains(StorageKinds, flags.Storage) {
+		fmt.Printf("Storage: %s\n", flags.Storage)
+		flags.ValidInputOther[10] = true
+	} else {
+		fmt.Printf("Error: unknown 'storage' %s. Use one of %v\n", flags.Storage, StorageKinds)
+		flags.ValidInputOther[10] = false
+	}
+
+	//EC-flag OPTIONAL
+
+	flags.ValidInputOther[11] = true //Since optional
+	if flags.EC != "" {
+		_, err := fmt.Sscanf(flags.EC, "%d+%d", &flags.ECData, &flags.ECParity)
+		if err != nil || flags.ECData < 1 || flags.ECData > 64 || flags.ECParity < 1 || flags.ECParity > 64 {
+			fmt.Println("Error: 'ec' must be k+m with k and m in the range [1,64], e.g. 4+2")
+			flags.ValidInputOther[11] = false
+		} else {
+			fmt.Printf("Files are stored as %d data and %d parity fragments\n", flags.ECData, flags.ECParity)
+		}
+	}
+
+	//TEX-flag OPTIONAL
+
+	if flags.Tex >= 1 && flags.Tex <= 3600000 {
+		fmt.Printf("Time between expiry rounds: %d\n", flags.Tex)
+		flags.ValidInputOther[12] = true
+	} else {
+		fmt.Println("Error: 'tex' value out of range. Range [1,3600000]")
+		flags.ValidInputOther[12] = false
+	}
+
+	//TAE-flag OPTIONAL
+
+	if flags.Tae >= 1 && flags.Tae <= 3600000 {
+		fmt.Printf("Time between anti-entropy rounds: %d\n", flags.Tae)
+		flags.ValidInputOther[13] = true
+	} else {
+		fmt.Println("Error: 'tae' value out of range. Range [1,3600000]")
+		flags.ValidInputOther[13] = false
+	}
+
+	//DATA-DIR-flag OPTIONAL
+
+	dataDir, err := filepath.Abs(flags.DataDir) //Stays the same if the working directory changes
+	if err == nil {
+		err = os.MkdirAll(dataDir, os.ModePerm)
+	}
+	if err != nil {
+		fmt.Printf("Error: could not use 'data-dir' %s: %s\n", flags.DataDir, err)
+		flags.ValidInputOther[14] = false
+	} else {
+		fmt.Printf("Data directory: %s\n", dataDir)
+		flags.DataDir = dataDir
+		flags.ValidInputOther[14] = true
+	}
+
+	//CACHE-flag OPTIONAL
+
+	if flags.Cache >= 0 && flags.Cache <= 4096 {
+		fmt.Printf("Cache size: %d MiB\n", flags.Cache)
+		flags.ValidInputOther[15] = true
+	} else {
+		fmt.Println("Error: 'cache' value out of range. Range [0,4096]")
+		flags.ValidInputOther[15] = false
+	}
+
+	//LOOKUP-flag OPTIONAL
+
+	if contains(LookupModes, flags.Lookup) {
+		fmt.Printf("Lookup mode: %s\n", flags.Lookup)
+		flags.ValidInputOther[16] = true
+	} else {
+		fmt.Printf("Error: unknown 'lookup' %s. Use one of %v\n", flags.Lookup, LookupModes)
+		flags.ValidInputOther[16] = false
+	}
 }
 
 /*
@@ -230,7 +383,7 @@ func checkValidInputJoin() bool {
 }
 
 /*
-checkValidInputJOther checks if the argument Ts, tff, tcp, r , i (userId) & m is valid.
+checkValidInputJOther checks if the argument Ts, tff, tcp, r , i (userId), m, k, versions, key, tsc, storage, ec & tex is valid.
 Since -i is optional it's always valid if it's not given. M flag can only be valid if
 -ja and -jp is not given. A user cannot join a ring and specify a different ringsize.
 */
@@ -0,0 +1,275 @@
+package Chord
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Files stored with --key are encrypted on the client with AES-256-GCM before StoreFile sends them,
+and decrypted by GetFile after the download. Storage nodes only see the ciphertext.
+
+The file is encrypted in segments so it never has to fit in memory:
+  header:   encryptionMagic | 8 byte random nonce prefix
+  segments: AES-GCM(segment of at most SegmentSize bytes), nonce = prefix | segment number (4 bytes)
+The last segment is always shorter than SegmentSize (it can be empty) and is sealed with a different
+additional data byte, so a truncated or reordered file fails authentication.
+*/
+
+const encryptionMagic = "CHORDEC1"
+
+const SegmentSize = 64 * 1024
+
+var ErrAuthentication = errors.New("authentication failed: wrong key, or the file was modified")
+
+/*
+readKeyFile reads a 256 bit key from keyPath. The file holds either the 32 raw bytes or 64 hex characters.
+*/
+func readKeyFile(keyPath string) ([]byte, error) {
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 32 {
+		return content, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must contain 32 bytes or 64 hex characters", keyPath)
+	}
+	return key, nil
+}
+
+/*
+newGCM creates an AES-GCM cipher from the key.
+*/
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+segmentNonce builds the nonce of a segment from the random prefix of the file and the segment number.
+*/
+func segmentNonce(prefix []byte, segment uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], segment)
+	return nonce
+}
+
+/*
+segmentData is the additional data of a segment, it marks the last segment of the file.
+*/
+func segmentData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+/*
+encryptFile encrypts the file on srcPath into dstPath with the given key. Returns the size and the SHA-256 (hex) of
+the plaintext it encrypted.
+*/
+func encryptFile(srcPath string, dstPath string, key []byte) (int64, string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return 0, "", err
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer dst.Close()
+	writer := bufio.NewWriter(dst)
+
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return 0, "", err
+	}
+	writer.WriteString(encryptionMagic)
+	writer.Write(prefix)
+
+	hasher := sha256.New()
+	reader := io.TeeReader(bufio.NewReader(src), hasher)
+	plain := make([]byte, SegmentSize)
+	size := int64(0)
+	for segment := uint32(0); ; segment++ {
+		read, err := io.ReadFull(reader, plain)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, "", err
+		}
+		size += int64(read)
+		last := read < SegmentSize //A full segment is followed by at least an empty last one
+
+		sealed := gcm.Seal(nil, segmentNonce(prefix, segment), plain[:read], segmentData(last))
+		if _, err := writer.Write(sealed); err != nil {
+			return 0, "", err
+		}
+		if last {
+			break
+		}
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), writer.Flush()
+}
+
+/*
+uploadFingerprint identifies what an encrypted upload was made from: the absolute path of the source, its size,
+the SHA-256 of its content and the key. Two uploads with the same fingerprint can send the same encrypted file.
+*/
+func uploadFingerprint(filePath string, size int64, plainHash string, key []byte) string {
+	absolute, err := filepath.Abs(filePath)
+	if err != nil {
+		absolute = filePath
+	}
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\x00%d\x00%s\x00", absolute, size, plainHash)
+	hasher.Write(key)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+/*
+encryptForUpload encrypts the file on filePath into the partial directory and returns the path of the encrypted file.
+An encrypted file left by an earlier interrupted upload is reused, so the upload can continue where it stopped, but
+only if it was made from the same source path, content and key: its fingerprint is saved next to it in <path>.source.
+*/
+func (n *Node) encryptForUpload(filePath string, fileName string) (string, error) {
+	encryptedPath := fmt.Sprintf("%s/%s/%s.enc", n.localPartialDirectory(), "upload", escapeName(fileName))
+
+	if saved, err := os.ReadFile(encryptedPath + ".source"); err == nil && isEncrypted(encryptedPath) {
+		size, err := localDisk.Size(filePath)
+		if err != nil {
+			return "", err
+		}
+		plainHash, err := hashFile(localDisk, filePath)
+		if err != nil {
+			return "", err
+		}
+		if string(saved) == uploadFingerprint(filePath, size, plainHash, n.encryptionKey) {
+			return encryptedPath, nil
+		}
+	}
+	removeUpload(encryptedPath)
+
+	err := os.MkdirAll(path.Dir(encryptedPath), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	size, plainHash, err := encryptFile(filePath, encryptedPath, n.encryptionKey)
+	if err == nil {
+		//The fingerprint of what was encrypted, the file may have changed since it was hashed
+		err = os.WriteFile(encryptedPath+".source", []byte(uploadFingerprint(filePath, size, plainHash, n.encryptionKey)), 0600)
+	}
+	if err != nil {
+		removeUpload(encryptedPath)
+	}
+	return encryptedPath, err
+}
+
+/*
+removeUpload deletes an encrypted upload made by encryptForUpload and its fingerprint.
+*/
+func removeUpload(encryptedPath string) {
+	os.Remove(encryptedPath)
+	os.Remove(encryptedPath + ".source")
+}
+
+/*
+isEncrypted returns true if the file on filePath starts with the header written by encryptFile.
+*/
+func isEncrypted(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, []byte(encryptionMagic))
+}
+
+/*
+decryptFile decrypts the file on srcPath, written by encryptFile, into dstPath.
+Returns ErrAuthentication if the key is wrong or the content has been changed. dstPath is removed then.
+*/
+func decryptFile(srcPath string, dstPath string, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	reader := bufio.NewReader(src)
+
+	header := make([]byte, len(encryptionMagic)+8)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return fmt.Errorf("%s is not an encrypted file", srcPath)
+	}
+	prefix := header[len(encryptionMagic):]
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(dst)
+
+	sealed := make([]byte, SegmentSize+gcm.Overhead())
+	for segment := uint32(0); ; segment++ {
+		read, err := io.ReadFull(reader, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			dst.Close()
+			os.Remove(dstPath)
+			return err
+		}
+		last := read < len(sealed)
+
+		plain, err := gcm.Open(nil, segmentNonce(prefix, segment), sealed[:read], segmentData(last))
+		if err != nil {
+			dst.Close()
+			os.Remove(dstPath)
+			return ErrAuthentication
+		}
+		writer.Write(plain)
+		if last {
+			break
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,190 @@
+package Chord
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+testKey returns a 256 bit key made from seed.
+*/
+func testKey(seed byte) []byte {
+	return bytes.Repeat([]byte{seed}, 32)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	directory := t.TempDir()
+	random := rand.New(rand.NewSource(3))
+	for _, size := range []int{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 2 * SegmentSize, 3*SegmentSize + 17} {
+		content := make([]byte, size)
+		random.Read(content)
+		plainPath := filepath.Join(directory, "plain")
+		encryptedPath := filepath.Join(directory, "encrypted")
+		decryptedPath := filepath.Join(directory, "decrypted")
+		os.WriteFile(plainPath, content, 0644)
+
+		encryptedSize, plainHash, err := encryptFile(plainPath, encryptedPath, testKey(1))
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if encryptedSize != int64(size) || plainHash != checksum(content) {
+			t.Errorf("size %d: encryptFile read %d bytes with hash %s", size, encryptedSize, plainHash)
+		}
+		if !isEncrypted(encryptedPath) || isEncrypted(plainPath) && size >= len(encryptionMagic) {
+			t.Errorf("size %d: isEncrypted does not tell the files apart", size)
+		}
+		if err := decryptFile(encryptedPath, decryptedPath, testKey(1)); err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if decrypted, _ := os.ReadFile(decryptedPath); !bytes.Equal(decrypted, content) {
+			t.Errorf("size %d: the decrypted file differs", size)
+		}
+
+		//Every segment has its own nonce, so the same content encrypts differently every time
+		other := filepath.Join(directory, "again")
+		encryptFile(plainPath, other, testKey(1))
+		first, _ := os.ReadFile(encryptedPath)
+		second, _ := os.ReadFile(other)
+		if bytes.Equal(first, second) {
+			t.Errorf("size %d: encrypting twice gave the same file", size)
+		}
+	}
+}
+
+func TestDecryptRefusesChanges(t *testing.T) {
+	directory := t.TempDir()
+	plainPath := filepath.Join(directory, "plain")
+	encryptedPath := filepath.Join(directory, "encrypted")
+	content := bytes.Repeat([]byte("chord"), SegmentSize) //Five segments, the last one shorter
+	os.WriteFile(plainPath, content, 0644)
+	if _, _, err := encryptFile(plainPath, encryptedPath, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, _ := os.ReadFile(encryptedPath)
+	header := len(encryptionMagic) + 8
+	sealedSegment := SegmentSize + 16 //GCM adds a 16 byte tag
+
+	tests := []struct {
+		name    string
+		content []byte
+		key     []byte
+	}{
+		{"wrong key", encrypted, testKey(2)},
+		{"flipped byte", append(append([]byte(nil), encrypted[:header+100]...), append([]byte{encrypted[header+100] ^ 1}, encrypted[header+101:]...)...), testKey(1)},
+		{"last segment cut off", encrypted[:header+4*sealedSegment], testKey(1)},
+		{"cut inside a segment", encrypted[:len(encrypted)-5], testKey(1)},
+		{"segments swapped", append(append(append([]byte(nil), encrypted[:header]...), encrypted[header+sealedSegment:header+2*sealedSegment]...),
+			encrypted[header:header+sealedSegment]...), testKey(1)},
+	}
+	for _, test := range tests {
+		changedPath := filepath.Join(directory, "changed")
+		decryptedPath := filepath.Join(directory, "decrypted")
+		os.WriteFile(changedPath, test.content, 0644)
+		if err := decryptFile(changedPath, decryptedPath, test.key); err != ErrAuthentication {
+			t.Errorf("%s: decryptFile = %v, want ErrAuthentication", test.name, err)
+		}
+		if _, err := os.Stat(decryptedPath); !os.IsNotExist(err) {
+			t.Errorf("%s: the destination was left: %v", test.name, err)
+		}
+	}
+
+	if err := decryptFile(plainPath, filepath.Join(directory, "decrypted"), testKey(1)); err == nil || err == ErrAuthentication {
+		t.Errorf("decrypting a file that is not encrypted: %v", err)
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	directory := t.TempDir()
+	tests := []struct {
+		name    string
+		content []byte
+		valid   bool
+	}{
+		{"raw", testKey(7), true},
+		{"hex", []byte(hex.EncodeToString(testKey(7))), true},
+		{"hex with newline", []byte(hex.EncodeToString(testKey(7)) + "\n"), true},
+		{"short", testKey(7)[:16], false},
+		{"short hex", []byte(hex.EncodeToString(testKey(7)[:31])), false},
+		{"not hex", bytes.Repeat([]byte("z"), 64), false},
+	}
+	for _, test := range tests {
+		keyPath := filepath.Join(directory, test.name)
+		os.WriteFile(keyPath, test.content, 0600)
+		key, err := readKeyFile(keyPath)
+		if (err == nil) != test.valid || test.valid && !bytes.Equal(key, testKey(7)) {
+			t.Errorf("%s: readKeyFile = %x, %v", test.name, key, err)
+		}
+	}
+}
+
+func TestEncryptForUploadCache(t *testing.T) {
+	n := &Node{dataDirectory: t.TempDir(), encryptionKey: testKey(1)}
+	directory := t.TempDir()
+	source := filepath.Join(directory, "report.txt")
+	os.WriteFile(source, []byte("first content"), 0644)
+
+	upload := func() []byte {
+		encryptedPath, err := n.encryptForUpload(source, "report.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, _ := os.ReadFile(encryptedPath)
+		return encrypted
+	}
+	decrypted := func(encrypted []byte) string {
+		encryptedPath := filepath.Join(directory, "check.enc")
+		os.WriteFile(encryptedPath, encrypted, 0644)
+		if err := decryptFile(encryptedPath, encryptedPath+".plain", n.encryptionKey); err != nil {
+			return err.Error()
+		}
+		plain, _ := os.ReadFile(encryptedPath + ".plain")
+		return string(plain)
+	}
+
+	first := upload()
+	if !bytes.Equal(upload(), first) {
+		t.Error("an interrupted upload of the same file was encrypted again, it cannot continue")
+	}
+
+	//Other content of the same size and modification time
+	info, _ := os.Stat(source)
+	os.WriteFile(source, []byte("other content"), 0644)
+	os.Chtimes(source, time.Now(), info.ModTime())
+	if got := decrypted(upload()); got != "other content" {
+		t.Errorf("changed content: the upload holds %q", got)
+	}
+
+	//Another file with the same name
+	otherSource := filepath.Join(t.TempDir(), "report.txt")
+	os.WriteFile(otherSource, []byte("another file"), 0644)
+	encryptedPath, _ := n.encryptForUpload(otherSource, "report.txt")
+	if encrypted, _ := os.ReadFile(encryptedPath); decrypted(encrypted) != "another file" {
+		t.Errorf("another source with the same name: the upload holds %q", decrypted(encrypted))
+	}
+
+	//Another key
+	cached := upload()
+	n.encryptionKey = testKey(2)
+	if bytes.Equal(upload(), cached) {
+		t.Error("the file encrypted with the old key was reused")
+	}
+
+	//A file encrypted without a fingerprint, e.g. cut off by a crash, is not reused
+	cached = upload()
+	os.Remove(encryptedPath + ".source")
+	if bytes.Equal(upload(), cached) {
+		t.Error("an encrypted file without a fingerprint was reused")
+	}
+	if _, err := os.Stat(encryptedPath + ".source"); err != nil {
+		t.Errorf("no fingerprint after encrypting again: %s", err)
+	}
+	removeUpload(encryptedPath)
+	if _, err := os.Stat(encryptedPath + ".source"); !os.IsNotExist(err) {
+		t.Error("removeUpload left the fingerprint")
+	}
+}
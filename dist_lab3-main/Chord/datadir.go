@@ -0,0 +1,169 @@
+package Chord
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Every node keeps its files in its own directory below --data-dir, whatever directory it is started in. The
+directory is named after the ring and the ID of the node, so nodes with the same ID in different rings do not clash:
+
+	<data-dir>/ring<ring ID>/node<ID>/node.json   - the metadata below
+	<data-dir>/ring<ring ID>/node<ID>/bucket      - the versions of the files the node is responsible for
+	<data-dir>/ring<ring ID>/node<ID>/replica     - the copies of files its predecessors are responsible for
+	<data-dir>/ring<ring ID>/node<ID>/blobs       - the content of all versions, by hash
+	<data-dir>/ring<ring ID>/node<ID>/partial     - uploads and downloads that are not complete yet
+	<data-dir>/ring<ring ID>/node<ID>/store.db    - everything above in one file, with --storage file
+
+A ring is identified by a random ring ID, made by the node that creates it and learned by every node that joins
+along with m. A node that creates a ring looks for a directory of its ID last used on its address, and restarts the
+ring of that directory and keeps its ID. The metadata records m and the ring, a node does not start on a directory
+of a ring of another size. A node also does not start on a directory last used on another address, unless
+--force-data-dir is given, since two nodes with the same ID on different machines or ports would otherwise share it.
+*/
+
+// The default --data-dir, below the home directory of the user.
+const DefaultDataDir = ".chord"
+
+// The metadata of a node's data directory.
+type NodeMetadata struct {
+	M       int    //Size of the ring the files belong to
+	ID      string //Identifier of the node on the ring
+	Address string //Address ("ip:port") the node last ran on
+	RingID  string //Random identifier of the ring, see newRingID
+}
+
+const metadataFile = "node.json"
+
+/*
+defaultDataDir returns ~/.chord, or .chord in the current directory if there is no home directory.
+*/
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return DefaultDataDir
+	}
+	return filepath.Join(home, DefaultDataDir)
+}
+
+/*
+newRingID returns a random identifier for a new ring.
+*/
+func newRingID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err) //crypto/rand does not fail on supported platforms
+	}
+	return hex.EncodeToString(id)
+}
+
+/*
+restartedRing returns the ring ID for a node that creates a ring: the ring of the directory of its ID that was
+last used on its address, or on any address if force is true, and a new ring ID if there is no such directory.
+Returns an error if the directories of several rings match, it is not clear which ring to restart.
+*/
+func (n *Node) restartedRing(dataDir string, force bool) (string, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	rings := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "ring") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dataDir, entry.Name(), "node"+n.Id.String(), metadataFile))
+		if err != nil {
+			continue
+		}
+		stored := NodeMetadata{}
+		if json.Unmarshal(content, &stored) != nil || "ring"+stored.RingID != entry.Name() {
+			continue
+		}
+		if stored.Address == n.Address || force {
+			rings = append(rings, stored.RingID)
+		}
+	}
+
+	switch len(rings) {
+	case 0:
+		return newRingID(), nil
+	case 1:
+		return rings[0], nil
+	}
+	return "", fmt.Errorf("%s holds directories of node %s in %d rings (%s), it is not clear which one to restart. Use another --data-dir", dataDir, n.Id.String(), len(rings), strings.Join(rings, ", "))
+}
+
+/*
+openDataDirectory creates or opens the node's directory below dataDir and sets n.dataDirectory. The metadata file
+is written on first use. Returns an error if the directory belongs to a ring of another size, to another ring or
+to another ID, or was last used on another address and force is false. A node that creates a ring (n.ringID is
+empty) takes the ring ID from restartedRing.
+*/
+func (n *Node) openDataDirectory(dataDir string, force bool) error {
+	if n.ringID == "" {
+		ringID, err := n.restartedRing(dataDir, force)
+		if err != nil {
+			return err
+		}
+		n.ringID = ringID
+	}
+	directory := filepath.Join(dataDir, "ring"+n.ringID, "node"+n.Id.String())
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return err
+	}
+	metadataPath := filepath.Join(directory, metadataFile)
+
+	content, err := os.ReadFile(metadataPath)
+	if err == nil {
+		stored := NodeMetadata{}
+		if err := json.Unmarshal(content, &stored); err != nil {
+			return fmt.Errorf("%s is not a node metadata file: %s", metadataPath, err)
+		}
+		if stored.M != n.M {
+			return fmt.Errorf("%s belongs to a ring with m = %d, this ring has m = %d. Use another --data-dir", directory, stored.M, n.M)
+		}
+		if stored.RingID != n.ringID { //A directory that was moved or copied by hand
+			return fmt.Errorf("%s belongs to ring %s, this node is in ring %s. Use another --data-dir", directory, stored.RingID, n.ringID)
+		}
+		if stored.ID != n.Id.String() {
+			return fmt.Errorf("%s belongs to node %s, this node has ID %s. Use another --data-dir", directory, stored.ID, n.Id.String())
+		}
+		if stored.Address != n.Address && !force {
+			return fmt.Errorf("%s was last used by the node on %s, this node runs on %s. Use another --data-dir, or --force-data-dir to take it over", directory, stored.Address, n.Address)
+		}
+		if stored.Address == n.Address && stored.RingID == n.ringID {
+			n.dataDirectory = directory
+			return nil
+		}
+		fmt.Printf("Data directory %s was last used on %s\n", directory, stored.Address)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	current := NodeMetadata{M: n.M, ID: n.Id.String(), Address: n.Address, RingID: n.ringID}
+
+	content, err = json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(metadataPath, content, 0644); err != nil {
+		return err
+	}
+	n.dataDirectory = directory
+	return nil
+}
+
+/*
+releaseDataDirectory removes the metadata after the node has handed over its files on Exit,
+the directory holds nothing of the ring anymore.
+*/
+func (n *Node) releaseDataDirectory() {
+	CheckError(os.Remove(filepath.Join(n.dataDirectory, metadataFile)), "Remove in releaseDataDirectory")
+}
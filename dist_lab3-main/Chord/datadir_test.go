@@ -0,0 +1,78 @@
+package Chord
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+dataDirNode returns a node that is not started, with the fields openDataDirectory uses.
+*/
+func dataDirNode(id int64, address string, ringID string) *Node {
+	return &Node{Id: *big.NewInt(id), Address: address, M: 10, ringID: ringID}
+}
+
+func TestOpenDataDirectory(t *testing.T) {
+	dataDir := t.TempDir()
+	creator := dataDirNode(7, "127.0.0.1:1111", "")
+	if err := creator.openDataDirectory(dataDir, false); err != nil {
+		t.Fatalf("first use: %s", err)
+	}
+	if creator.ringID == "" {
+		t.Fatal("creating a ring made no ring ID")
+	}
+	ring := creator.ringID
+	if want := filepath.Join(dataDir, "ring"+ring, "node7"); creator.dataDirectory != want {
+		t.Errorf("data directory %s, want %s", creator.dataDirectory, want)
+	}
+
+	tests := []struct {
+		name    string
+		node    *Node
+		force   bool
+		refused string //Part of the error, empty if the node may use the directory
+		ring    string //The ring ID the node ends up in if it may
+	}{
+		{"restart of the creator keeps the ring", dataDirNode(7, "127.0.0.1:1111", ""), false, "", ring},
+		{"restart after joining the same ring", dataDirNode(7, "127.0.0.1:1111", ring), false, "", ring},
+		{"same ID on another address", dataDirNode(7, "127.0.0.2:1111", ring), false, "--force-data-dir", ""},
+		{"another m", &Node{Id: *big.NewInt(7), Address: "127.0.0.1:1111", M: 11, ringID: ring}, false, "m = 10", ""},
+		{"creating a ring on another address", dataDirNode(7, "127.0.0.3:1111", ""), false, "", "new"},
+		{"same ID and m in another ring", dataDirNode(7, "127.0.0.1:1111", "another"), false, "", "another"},
+		{"restart of a creator with two rings", dataDirNode(7, "127.0.0.1:1111", ""), false, "which one to restart", ""},
+	}
+	for _, test := range tests {
+		err := test.node.openDataDirectory(dataDir, test.force)
+		switch {
+		case test.refused == "" && err != nil:
+			t.Errorf("%s: refused: %s", test.name, err)
+		case test.refused != "" && (err == nil || !strings.Contains(err.Error(), test.refused)):
+			t.Errorf("%s: error %v, want one about %q", test.name, err, test.refused)
+		case test.ring == "new" && (test.node.ringID == "" || test.node.ringID == ring):
+			t.Errorf("%s: ring ID %q, want a new one", test.name, test.node.ringID)
+		case test.ring != "" && test.ring != "new" && test.node.ringID != test.ring:
+			t.Errorf("%s: ring ID %s, want %s", test.name, test.node.ringID, test.ring)
+		}
+	}
+
+	//With --force-data-dir the directory moves to the new address, and the old address is refused after that
+	moved := dataDirNode(7, "127.0.0.2:1111", ring)
+	if err := moved.openDataDirectory(dataDir, true); err != nil {
+		t.Fatalf("taking over with force: %s", err)
+	}
+	if err := dataDirNode(7, "127.0.0.1:1111", ring).openDataDirectory(dataDir, false); err == nil {
+		t.Error("the old address could use the directory after it was taken over")
+	}
+
+	//A directory copied below the name of another ring is refused
+	copied := filepath.Join(dataDir, "ringcopied", "node7")
+	os.MkdirAll(copied, 0755)
+	content, _ := os.ReadFile(filepath.Join(creator.dataDirectory, metadataFile))
+	os.WriteFile(filepath.Join(copied, metadataFile), content, 0644)
+	if err := dataDirNode(7, "127.0.0.2:1111", "copied").openDataDirectory(dataDir, true); err == nil || !strings.Contains(err.Error(), "belongs to ring") {
+		t.Errorf("a directory of ring %s below ringcopied: error %v", ring, err)
+	}
+}
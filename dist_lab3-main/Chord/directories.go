@@ -0,0 +1,144 @@
+package Chord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+StoreDir stores every file below a directory as <dirname>/<relative path>, and a manifest listing them as
+<dirname>.manifest. GetDir reads the manifest and fetches the files into the same layout.
+*/
+
+// The manifest of a stored directory.
+type Manifest struct {
+	Name  string //Name of the directory, the prefix of the stored filenames
+	Files []ManifestEntry
+}
+
+// A file in a stored directory. Path is relative to the directory and uses slashes.
+type ManifestEntry struct {
+	Path string
+	Size int64
+	Hash string //SHA-256 of the file before encryption, checked after GetDir
+}
+
+const manifestSuffix = ".manifest"
+
+/*
+StoreDir, Takes a directory path. Stores every file below it with its relative path in the name, then stores
+a manifest of the files. Files that fail are left out of the manifest. Returns the name of the manifest.
+*/
+func (n *Node) StoreDir(dirPath string) string {
+	dirName := filepath.Base(filepath.Clean(dirPath))
+	if info, err := os.Stat(dirPath); err != nil || !info.IsDir() || dirName == "." || dirName == string(filepath.Separator) {
+		fmt.Printf("StoreDir failed: %s is not a directory\n", dirPath)
+		return ""
+	}
+
+	manifest := Manifest{Name: dirName, Files: make([]ManifestEntry, 0)}
+	failed := 0
+	err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Printf("StoreDir: skipping %s: %s\n", filePath, err)
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil //Directories are rebuilt from the paths, links and devices are not stored
+		}
+		relative, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		hash, err := hashFile(localDisk, filePath)
+		if err != nil {
+			fmt.Printf("StoreDir: skipping %s: %s\n", filePath, err)
+			return nil
+		}
+		if !n.storeAs(filePath, path.Join(dirName, relative), 0) {
+			failed++
+			return nil
+		}
+		manifest.Files = append(manifest.Files, ManifestEntry{Path: relative, Size: fileSize(localDisk, filePath), Hash: hash})
+		return nil
+	})
+	if CheckError(err, "WalkDir in StoreDir") {
+		return ""
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if CheckError(err, "Marshal in StoreDir") {
+		return ""
+	}
+	manifestName := dirName + manifestSuffix
+	manifestPath := fmt.Sprintf("%s/%s/%s", n.localPartialDirectory(), "upload", manifestName)
+	err = localDisk.WriteFile(manifestPath, content)
+	if CheckError(err, "WriteFile in StoreDir") {
+		return ""
+	}
+	defer os.Remove(manifestPath)
+
+	if !n.storeAs(manifestPath, manifestName, 0) {
+		fmt.Printf("StoreDir failed: could not store the manifest %s\n", manifestName)
+		return ""
+	}
+	fmt.Printf("Stored %d files of %s (%d failed), get them with: GetDir %s\n", len(manifest.Files), dirPath, failed, manifestName)
+	return manifestName
+}
+
+/*
+GetDir, Takes the name of a manifest stored by StoreDir and a destination directory, the directory name
+if it is empty. Fetches the manifest and every file in it into the destination, rebuilding the directory tree.
+Returns true if all files were fetched and match the manifest.
+*/
+func (n *Node) GetDir(manifestName string, destDir string) bool {
+	if !strings.HasSuffix(manifestName, manifestSuffix) {
+		manifestName += manifestSuffix
+	}
+
+	manifestPath := fmt.Sprintf("%s/%s/%s", n.localPartialDirectory(), "download", manifestName)
+	if !n.GetFile(manifestName, manifestPath) {
+		return false
+	}
+	content, err := os.ReadFile(manifestPath)
+	os.Remove(manifestPath)
+	if CheckError(err, "ReadFile in GetDir") {
+		return false
+	}
+	manifest := Manifest{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		fmt.Printf("GetDir failed: %s is not a manifest: %s\n", manifestName, err)
+		return false
+	}
+
+	if destDir == "" {
+		destDir = manifest.Name
+	}
+
+	fetched := 0
+	for _, entry := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(entry.Path)) {
+			fmt.Printf("GetDir: skipping %s, it points outside the directory\n", entry.Path)
+			continue
+		}
+		destPath := filepath.Join(destDir, filepath.FromSlash(entry.Path))
+		if !n.GetFile(path.Join(manifest.Name, entry.Path), destPath) {
+			continue
+		}
+		if hash, err := hashFile(localDisk, destPath); err != nil || hash != entry.Hash {
+			fmt.Printf("GetDir: %s does not match the manifest\n", destPath)
+			continue
+		}
+		fetched++
+	}
+
+	fmt.Printf("Fetched %d of %d files of %s into %s\n", fetched, len(manifest.Files), manifest.Name, destDir)
+	return fetched == len(manifest.Files)
+}
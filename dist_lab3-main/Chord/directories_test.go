@@ -0,0 +1,84 @@
+package Chord
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRingStoreDirGetDir(t *testing.T) {
+	nodes := startRing(t, 2)
+	tests := []struct {
+		path    string //Relative to the stored directory
+		content string
+	}{
+		{"top.txt", "at the top"},
+		{"empty", ""},
+		{"sub/nested.txt", "one level down"},
+		{"sub/deeper/deepest.bin", "\x00\x01\x02"},
+		{"other/sub/same.txt", "at the top"}, //The same content under another name
+		{"with space/ä.txt", "unicode"},
+	}
+	dirPath := filepath.Join(t.TempDir(), "tree")
+	for _, test := range tests {
+		filePath := filepath.Join(dirPath, filepath.FromSlash(test.path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(dirPath, "empty dir"), 0755)                               //Not stored, only files are
+	os.Symlink(filepath.Join(dirPath, "top.txt"), filepath.Join(dirPath, "link.txt")) //Not stored, links are skipped
+
+	manifestName := nodes[0].StoreDir(dirPath)
+	if manifestName != "tree"+manifestSuffix {
+		t.Fatalf("StoreDir returned %q", manifestName)
+	}
+
+	destDir := filepath.Join(t.TempDir(), "fetched")
+	if !nodes[1].GetDir("tree", destDir) {
+		t.Fatal("GetDir failed")
+	}
+	fetched := 0
+	filepath.WalkDir(destDir, func(filePath string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			fetched++
+		}
+		return nil
+	})
+	if fetched != len(tests) {
+		t.Errorf("GetDir fetched %d files, want %d", fetched, len(tests))
+	}
+	for _, test := range tests {
+		got, err := os.ReadFile(filepath.Join(destDir, filepath.FromSlash(test.path)))
+		if err != nil || string(got) != test.content {
+			t.Errorf("%s was fetched as %q (%v), want %q", test.path, got, err, test.content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "link.txt")); !os.IsNotExist(err) {
+		t.Errorf("the link was fetched: %v", err)
+	}
+
+	//A file that no longer matches the manifest fails GetDir, the other files are still fetched
+	changed := filepath.Join(t.TempDir(), "changed")
+	os.WriteFile(changed, []byte("changed"), 0644)
+	if !nodes[0].storeAs(changed, "tree/sub/nested.txt", 0) {
+		t.Fatal("storing the changed file failed")
+	}
+	destDir = filepath.Join(t.TempDir(), "changed")
+	if nodes[1].GetDir(manifestName, destDir) {
+		t.Error("GetDir succeeded with a file that does not match the manifest")
+	}
+	if got, err := os.ReadFile(filepath.Join(destDir, "top.txt")); err != nil || string(got) != "at the top" {
+		t.Errorf("top.txt was fetched as %q (%v) next to the changed file", got, err)
+	}
+
+	if nodes[0].StoreDir(filepath.Join(dirPath, "top.txt")) != "" {
+		t.Error("StoreDir stored a file that is not a directory")
+	}
+	if nodes[1].GetDir("missing", t.TempDir()) {
+		t.Error("GetDir succeeded without a manifest")
+	}
+}
@@ -0,0 +1,144 @@
+package Chord
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Reed-Solomon erasure code over GF(2^8). A stripe of k data shards is extended with m parity shards so that
+any k of the k+m shards are enough to get the data back. The encoding matrix is the k x k identity on top of
+an m x k Cauchy matrix, every k x k submatrix of it is invertible.
+*/
+
+// Exponent and logarithm tables of GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d) and generator 2.
+var gfExp [512]byte
+var gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255] //So gfMul does not need a modulo
+	}
+}
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]] //a is never 0 here
+}
+
+// An erasure code with Data data shards and Parity parity shards.
+type erasureCode struct {
+	Data   int
+	Parity int
+	matrix [][]byte //(Data+Parity) x Data, row i gives shard i from the data shards
+}
+
+func newErasureCode(data int, parity int) (*erasureCode, error) {
+	if data < 1 || parity < 0 || data+parity > 256 {
+		return nil, fmt.Errorf("cannot make an erasure code with %d data and %d parity shards", data, parity)
+	}
+	c := &erasureCode{Data: data, Parity: parity, matrix: make([][]byte, data+parity)}
+	for i := 0; i < data; i++ {
+		c.matrix[i] = make([]byte, data)
+		c.matrix[i][i] = 1
+	}
+	for i := 0; i < parity; i++ {
+		c.matrix[data+i] = make([]byte, data)
+		for j := 0; j < data; j++ {
+			c.matrix[data+i][j] = gfInv(byte(data+i) ^ byte(j)) //Cauchy: 1 / (x_i + y_j), all x_i and y_j distinct
+		}
+	}
+	return c, nil
+}
+
+/*
+mulRows sets out to the sum of rows[j] * shards[j]. All shards have the length of out.
+*/
+func mulRows(rows []byte, shards [][]byte, out []byte) {
+	for i := range out {
+		out[i] = 0
+	}
+	for j, factor := range rows {
+		if factor == 0 {
+			continue
+		}
+		logFactor := gfLog[factor]
+		for i, value := range shards[j] {
+			if value != 0 {
+				out[i] ^= gfExp[logFactor+gfLog[value]]
+			}
+		}
+	}
+}
+
+/*
+encode fills the parity shards shards[Data:] from the data shards shards[:Data]. All shards have the same length.
+*/
+func (c *erasureCode) encode(shards [][]byte) {
+	for i := 0; i < c.Parity; i++ {
+		mulRows(c.matrix[c.Data+i], shards[:c.Data], shards[c.Data+i])
+	}
+}
+
+/*
+decoder returns the matrix that gives the data shards from the shards with the given indices, which must be
+Data different indices.
+*/
+func (c *erasureCode) decoder(indices []int) ([][]byte, error) {
+	if len(indices) != c.Data {
+		return nil, fmt.Errorf("need %d shards to decode, have %d", c.Data, len(indices))
+	}
+	size := c.Data
+	//Gauss-Jordan elimination of [sub | I]
+	work := make([][]byte, size)
+	for i, index := range indices {
+		work[i] = make([]byte, 2*size)
+		copy(work[i], c.matrix[index])
+		work[i][size+i] = 1
+	}
+	for column := 0; column < size; column++ {
+		pivot := column
+		for pivot < size && work[pivot][column] == 0 {
+			pivot++
+		}
+		if pivot == size {
+			return nil, errors.New("the shards do not give an invertible matrix")
+		}
+		work[column], work[pivot] = work[pivot], work[column]
+
+		scale := gfInv(work[column][column])
+		for j := range work[column] {
+			work[column][j] = gfMul(work[column][j], scale)
+		}
+		for row := 0; row < size; row++ {
+			if row == column || work[row][column] == 0 {
+				continue
+			}
+			factor := work[row][column]
+			for j := range work[row] {
+				work[row][j] ^= gfMul(factor, work[column][j])
+			}
+		}
+	}
+
+	inverse := make([][]byte, size)
+	for i := range work {
+		inverse[i] = work[i][size:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,168 @@
+package Chord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+combinations returns every subset of size k of 0..n-1, in increasing order.
+*/
+func combinations(n int, k int) [][]int {
+	var all [][]int
+	var walk func(start int, chosen []int)
+	walk = func(start int, chosen []int) {
+		if len(chosen) == k {
+			all = append(all, append([]int(nil), chosen...))
+			return
+		}
+		for i := start; i < n; i++ {
+			walk(i+1, append(chosen, i))
+		}
+	}
+	walk(0, nil)
+	return all
+}
+
+func TestErasureCodeRoundTrip(t *testing.T) {
+	tests := []struct{ data, parity, length int }{
+		{1, 1, 7},
+		{2, 1, 16},
+		{3, 2, 33},
+		{4, 2, 64},
+		{5, 3, 5},
+		{6, 4, 101},
+		{10, 4, 3},
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		code, err := newErasureCode(test.data, test.parity)
+		if err != nil {
+			t.Fatalf("%d+%d: %s", test.data, test.parity, err)
+		}
+		shards := make([][]byte, test.data+test.parity)
+		for i := range shards {
+			shards[i] = make([]byte, test.length)
+			if i < test.data {
+				random.Read(shards[i])
+			}
+		}
+		code.encode(shards)
+
+		//Every choice of data shards out of the data and parity shards, so every combination of parity lost shards
+		for _, indices := range combinations(test.data+test.parity, test.data) {
+			decoder, err := code.decoder(indices)
+			if err != nil {
+				t.Errorf("%d+%d from shards %v: %s", test.data, test.parity, indices, err)
+				continue
+			}
+			available := make([][]byte, len(indices))
+			for i, index := range indices {
+				available[i] = shards[index]
+			}
+			out := make([]byte, test.length)
+			for i := 0; i < test.data; i++ {
+				mulRows(decoder[i], available, out)
+				if !bytes.Equal(out, shards[i]) {
+					t.Errorf("%d+%d from shards %v: data shard %d differs", test.data, test.parity, indices, i)
+				}
+			}
+		}
+	}
+}
+
+func TestErasureCodeErrors(t *testing.T) {
+	for _, test := range []struct{ data, parity int }{{0, 1}, {1, -1}, {200, 57}} {
+		if _, err := newErasureCode(test.data, test.parity); err == nil {
+			t.Errorf("newErasureCode(%d, %d) made a code", test.data, test.parity)
+		}
+	}
+	code, _ := newErasureCode(3, 2)
+	if _, err := code.decoder([]int{0, 4}); err == nil {
+		t.Error("decoded 3 data shards from 2 shards")
+	}
+	if _, err := code.decoder([]int{1, 1, 2}); err == nil {
+		t.Error("decoded from the same shard twice")
+	}
+}
+
+func TestErasureFragmentsRoundTrip(t *testing.T) {
+	n := &Node{}
+	const segment = 64
+	random := rand.New(rand.NewSource(2))
+	//Sizes around the segment and stripe boundaries, the odd ones are padded in the last stripe
+	for _, size := range []int{1, 63, 64, 65, 4*segment - 1, 4 * segment, 4*segment + 1, 1001} {
+		content := make([]byte, size)
+		random.Read(content)
+		source := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(source, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		code, _ := newErasureCode(4, 2)
+		directory := filepath.Join(t.TempDir(), "fragments")
+		if err := n.encodeFragments(code, source, directory, segment); err != nil {
+			t.Fatalf("size %d: encodeFragments: %s", size, err)
+		}
+		descriptor := ErasureDescriptor{Data: 4, Parity: 2, Size: int64(size), SegmentSize: segment}
+
+		for _, indices := range combinations(6, 4) {
+			dest := filepath.Join(t.TempDir(), fmt.Sprintf("rebuilt%v", indices))
+			if err := n.decodeFragments(code, descriptor, indices, directory, dest); err != nil {
+				t.Errorf("size %d from fragments %v: %s", size, indices, err)
+				continue
+			}
+			rebuilt, err := os.ReadFile(dest)
+			if err != nil || !bytes.Equal(rebuilt, content) {
+				t.Errorf("size %d from fragments %v: the rebuilt file differs", size, indices)
+			}
+		}
+	}
+}
+
+func TestReadDescriptor(t *testing.T) {
+	directory := t.TempDir()
+	want := ErasureDescriptor{Data: 4, Parity: 2, Size: 1001, SegmentSize: 64, Hash: "abc",
+		Fragments: []Fragment{{Index: 0, Name: "f#fragment0", Hash: "h0"}, {Index: 5, Name: "", Hash: ""}}}
+	encoded, _ := json.Marshal(want)
+
+	tests := []struct {
+		name       string
+		content    []byte
+		descriptor bool //isErasureDescriptor
+		valid      bool //readDescriptor succeeds
+	}{
+		{"descriptor", append([]byte(erasureMagic), encoded...), true, true},
+		{"plain file", []byte("just a file"), false, false},
+		{"magic without newline", append([]byte("CHORDRS1"), encoded...), false, false},
+		{"short file", []byte("CHORD"), false, false},
+		{"broken json", append([]byte(erasureMagic), encoded[:len(encoded)/2]...), true, false},
+	}
+	for _, test := range tests {
+		path := filepath.Join(directory, test.name)
+		if err := os.WriteFile(path, test.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := isErasureDescriptor(path); got != test.descriptor {
+			t.Errorf("%s: isErasureDescriptor = %t", test.name, got)
+		}
+		descriptor, err := readDescriptor(path)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: readDescriptor error %v", test.name, err)
+		}
+		if test.valid {
+			got, _ := json.Marshal(descriptor)
+			if !bytes.Equal(got, encoded) {
+				t.Errorf("%s: read %s, wrote %s", test.name, got, encoded)
+			}
+		}
+	}
+	if isErasureDescriptor(filepath.Join(directory, "missing")) {
+		t.Error("a missing file is a descriptor")
+	}
+}
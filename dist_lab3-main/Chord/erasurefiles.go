@@ -0,0 +1,411 @@
+package Chord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+With --ec k+m, StoreFile does not send the file to one node that copies it to its successors. The file is cut
+into k data fragments and m parity fragments, every fragment is stored under its own name <filename>#fragment<i>
+on a different node found with Lookup, and fragments are not copied. Under the filename itself a small
+descriptor is stored, it lists the fragments and is copied like any other file. GetFile fetches the descriptor,
+then any k fragments, and rebuilds the file. Up to m fragments can be lost with the nodes that held them.
+
+The data is striped: stripe s holds bytes [s*k*S, (s+1)*k*S) of the file, data fragment i gets bytes
+[i*S, (i+1)*S) of every stripe, S is the segment size. The last stripe is padded with zeros.
+*/
+
+const erasureMagic = "CHORDRS1\n"
+
+// The largest segment of a fragment in one stripe.
+const MaxFragmentSegment = 64 * 1024
+
+// Tries to find a node that holds no other fragment of the same file.
+const fragmentPlacementAttempts = 8
+
+// The descriptor stored under the filename of an erasure coded file.
+type ErasureDescriptor struct {
+	Data        int
+	Parity      int
+	Size        int64  //Size of the file
+	SegmentSize int64  //Bytes of a fragment in one stripe
+	Hash        string //SHA-256 of the file
+	Fragments   []Fragment
+}
+
+// A fragment of an erasure coded file, Name is empty if it could not be stored.
+type Fragment struct {
+	Index int
+	Name  string
+	Hash  string
+}
+
+// Marks the names of fragments, checkFileName refuses it in the names of files.
+const fragmentMarker = "#fragment"
+
+/*
+isFragmentName returns true for the names the fragments of erasure coded files are stored under.
+*/
+func isFragmentName(fileName string) bool {
+	return strings.Contains(fileName, fragmentMarker)
+}
+
+/*
+isErasureDescriptor returns true if the file on filePath is the descriptor of an erasure coded file.
+*/
+func isErasureDescriptor(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	header := make([]byte, len(erasureMagic))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return string(header) == erasureMagic
+}
+
+/*
+readDescriptor reads the descriptor in the file on filePath.
+*/
+func readDescriptor(filePath string) (ErasureDescriptor, error) {
+	descriptor := ErasureDescriptor{}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return descriptor, err
+	}
+	if !bytes.HasPrefix(content, []byte(erasureMagic)) {
+		return descriptor, fmt.Errorf("%s is not an erasure coded file", filePath)
+	}
+	err = json.Unmarshal(content[len(erasureMagic):], &descriptor)
+	return descriptor, err
+}
+
+/*
+fragmentsDirectory is the local directory where the fragments of a file are kept while they are sent or fetched.
+*/
+func (n *Node) fragmentsDirectory(kind string, fileName string) string {
+	return fmt.Sprintf("%s/%s/%s.fragments", n.localPartialDirectory(), kind, escapeName(fileName))
+}
+
+/*
+storeErasureCoded cuts the file on uploadPath into fragments, stores them and writes the descriptor of the file.
+The caller stores the descriptor under fileName. Returns the local path of the descriptor.
+*/
+func (n *Node) storeErasureCoded(fileName string, uploadPath string, hash string, ttl time.Duration) (string, error) {
+	code, err := newErasureCode(n.Flags.ECData, n.Flags.ECParity)
+	if err != nil {
+		return "", err
+	}
+
+	size := fileSize(localDisk, uploadPath)
+	segment := (size + int64(code.Data) - 1) / int64(code.Data)
+	if segment > MaxFragmentSegment {
+		segment = MaxFragmentSegment
+	}
+	if segment < 1 {
+		segment = 1
+	}
+
+	directory := n.fragmentsDirectory("upload", fileName)
+	defer os.RemoveAll(directory)
+	if err := n.encodeFragments(code, uploadPath, directory, segment); err != nil {
+		return "", err
+	}
+
+	descriptor := ErasureDescriptor{Data: code.Data, Parity: code.Parity, Size: size, SegmentSize: segment, Hash: hash}
+	used := make([]string, 0)
+	stored := 0
+	for i := 0; i < code.Data+code.Parity; i++ {
+		fragmentPath := fmt.Sprintf("%s/%d", directory, i)
+		fragmentHash, err := hashFile(localDisk, fragmentPath)
+		if err != nil {
+			return "", err
+		}
+
+		name, owner := n.placeFragment(fileName, i, used)
+		fragment := Fragment{Index: i, Hash: fragmentHash}
+		if owner != "" {
+			FileID := hashModulo(Hash(name), n.M2)
+			chunk := Chunk{ID: *FileID, FileName: name, Hash: fragmentHash, Uploader: n.uploaderID(), MimeType: "application/octet-stream", Expires: expiresAt(ttl)}
+			if n.sendFile(owner, chunk, localDisk, fragmentPath) {
+				fragment.Name = name
+				used = append(used, owner)
+				stored++
+			}
+		}
+		if fragment.Name == "" {
+			fmt.Printf("Could not store fragment %d of %s\n", i, fileName)
+		}
+		descriptor.Fragments = append(descriptor.Fragments, fragment)
+	}
+
+	if stored < code.Data {
+		return "", fmt.Errorf("only %d of the %d fragments needed to rebuild %s were stored", stored, code.Data, fileName)
+	}
+	if stored < code.Data+code.Parity {
+		fmt.Printf("Warning: %s was stored with %d of %d fragments, it survives %d lost fragments\n", fileName, stored, code.Data+code.Parity, stored-code.Data)
+	}
+
+	content, err := json.Marshal(descriptor)
+	if err != nil {
+		return "", err
+	}
+	descriptorPath := fmt.Sprintf("%s/%s/%s.descriptor", n.localPartialDirectory(), "upload", escapeName(fileName))
+	return descriptorPath, localDisk.WriteFile(descriptorPath, append([]byte(erasureMagic), content...))
+}
+
+/*
+encodeFragments reads the file on filePath one stripe at a time and writes fragment i to directory/i.
+*/
+func (n *Node) encodeFragments(code *erasureCode, filePath string, directory string, segment int64) error {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return err
+	}
+	fragments := make([]*os.File, code.Data+code.Parity)
+	shards := make([][]byte, code.Data+code.Parity)
+	for i := range fragments {
+		fragments[i], err = os.Create(fmt.Sprintf("%s/%d", directory, i))
+		if err != nil {
+			return err
+		}
+		defer fragments[i].Close()
+		shards[i] = make([]byte, segment)
+	}
+
+	for {
+		total := 0
+		for i := 0; i < code.Data; i++ {
+			read, err := io.ReadFull(source, shards[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			for j := read; j < len(shards[i]); j++ {
+				shards[i][j] = 0 //Padding of the last stripe
+			}
+			total += read
+		}
+		if total == 0 {
+			return nil
+		}
+		code.encode(shards)
+		for i, fragment := range fragments {
+			if _, err := fragment.Write(shards[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/*
+placeFragment finds the name and the owner of fragment i of a file. Names are tried until the owner is not in
+used, so the fragments end up on different nodes if the ring is large enough. Returns an empty owner if no node was found.
+*/
+func (n *Node) placeFragment(fileName string, i int, used []string) (string, string) {
+	name, owner := "", ""
+	for attempt := 0; attempt < fragmentPlacementAttempts; attempt++ {
+		candidate := fmt.Sprintf("%s%s%d", fileName, fragmentMarker, i)
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s.%d", candidate, attempt)
+		}
+		found, address := n.locate(candidate)
+		if !found {
+			continue
+		}
+		if owner == "" {
+			name, owner = candidate, address //Used if every attempt lands on a node that is used already
+		}
+		if !contains(used, address) {
+			return candidate, address
+		}
+	}
+	return name, owner
+}
+
+/*
+rebuildErasureCoded replaces the descriptor on descriptorPath with the file it describes, rebuilt from any k of
+its fragments. Returns the size of the file.
+*/
+func (n *Node) rebuildErasureCoded(descriptorPath string, fileName string) (int64, error) {
+	descriptor, err := readDescriptor(descriptorPath)
+	if err != nil {
+		return 0, err
+	}
+	code, err := newErasureCode(descriptor.Data, descriptor.Parity)
+	if err != nil {
+		return 0, err
+	}
+
+	directory := n.fragmentsDirectory("download", fileName)
+	defer os.RemoveAll(directory)
+
+	//Data fragments first, when they are all there nothing has to be calculated
+	indices := make([]int, 0, code.Data)
+	for _, fragment := range descriptor.Fragments {
+		if len(indices) == code.Data {
+			break
+		}
+		if fragment.Name == "" || fragment.Index < 0 || fragment.Index >= code.Data+code.Parity {
+			continue
+		}
+		err := n.fetchFragment(fragment, fmt.Sprintf("%s/%d", directory, fragment.Index))
+		if err != nil {
+			fmt.Printf("Fragment %d of %s is not available: %s\n", fragment.Index, fileName, err)
+			continue
+		}
+		indices = append(indices, fragment.Index)
+	}
+	if len(indices) < code.Data {
+		return 0, fmt.Errorf("only %d of the %d fragments needed to rebuild %s are available", len(indices), code.Data, fileName)
+	}
+
+	rebuiltPath := descriptorPath + ".rebuilt"
+	err = n.decodeFragments(code, descriptor, indices, directory, rebuiltPath)
+	if err != nil {
+		os.Remove(rebuiltPath)
+		return 0, err
+	}
+	if hash, err := hashFile(localDisk, rebuiltPath); err != nil || hash != descriptor.Hash {
+		os.Remove(rebuiltPath)
+		return 0, fmt.Errorf("%s rebuilt from its fragments does not match its checksum", fileName)
+	}
+	return descriptor.Size, os.Rename(rebuiltPath, descriptorPath)
+}
+
+/*
+locate finds the node responsible for a name. When nodes have just failed a lookup can run into a node
+that is gone before the finger tables are fixed, so it is tried again a few times.
+*/
+func (n *Node) locate(name string) (bool, string) {
+	id := hashModulo(Hash(name), n.M2)
+	for attempt := 0; ; attempt++ {
+		found, owner := n.find(*id, n.Address, MaxSteps)
+		if found || attempt >= MaxRetries {
+			return found, owner
+		}
+		time.Sleep(RetryDelay)
+	}
+}
+
+/*
+fetchFragment downloads the fragment into destPath, the version of it with the hash in the descriptor.
+*/
+func (n *Node) fetchFragment(fragment Fragment, destPath string) error {
+	FileID := hashModulo(Hash(fragment.Name), n.M2)
+	found, owner := n.locate(fragment.Name)
+	if !found {
+		return fmt.Errorf("no node found for %s", fragment.Name)
+	}
+
+	SenderArgs := SendArgs{ListVersionsRequest: true, File: File{ID: *FileID, FileName: fragment.Name}}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, owner) {
+		return fmt.Errorf("could not reach %s", owner)
+	}
+	if !ReceiveArgs.Answer {
+		return fmt.Errorf("%s", ReceiveArgs.ReplyArgs)
+	}
+	for _, version := range ReceiveArgs.Versions {
+		if version.Hash == fragment.Hash {
+			_, err := n.fetchFile(owner, Chunk{ID: *FileID, FileName: fragment.Name, Version: version.Number}, localDisk, destPath)
+			return err
+		}
+	}
+	return fmt.Errorf("%s on %s is of another version of the file", fragment.Name, owner)
+}
+
+/*
+decodeFragments writes the file rebuilt from the fragments with the given indices in directory to destPath.
+*/
+func (n *Node) decodeFragments(code *erasureCode, descriptor ErasureDescriptor, indices []int, directory string, destPath string) error {
+	decoder, err := code.decoder(indices)
+	if err != nil {
+		return err
+	}
+
+	fragments := make([]*os.File, len(indices))
+	shards := make([][]byte, len(indices))
+	for i, index := range indices {
+		fragments[i], err = os.Open(fmt.Sprintf("%s/%d", directory, index))
+		if err != nil {
+			return err
+		}
+		defer fragments[i].Close()
+		shards[i] = make([]byte, descriptor.SegmentSize)
+	}
+
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	data := make([]byte, descriptor.SegmentSize)
+	remaining := descriptor.Size
+	for remaining > 0 {
+		for i, fragment := range fragments {
+			if _, err := io.ReadFull(fragment, shards[i]); err != nil {
+				return fmt.Errorf("fragment %d is too short: %s", indices[i], err)
+			}
+		}
+		for i := 0; i < code.Data && remaining > 0; i++ {
+			mulRows(decoder[i], shards, data)
+			length := int64(len(data))
+			if length > remaining {
+				length = remaining //Padding of the last stripe
+			}
+			if _, err := dest.Write(data[:length]); err != nil {
+				return err
+			}
+			remaining -= length
+		}
+	}
+	return dest.Close()
+}
+
+/*
+fragmentNames returns the names of the fragments of the erasure coded versions of a file, by fetching the
+descriptor of every version that has Erasure set.
+*/
+func (n *Node) fragmentNames(fileName string, versions []Version) []string {
+	names := make([]string, 0)
+	for _, version := range versions {
+		if !version.Erasure {
+			continue
+		}
+		FileID := hashModulo(Hash(fileName), n.M2)
+		found, owner := n.locate(fileName)
+		if !found {
+			continue
+		}
+		descriptorPath := fmt.Sprintf("%s/%s/%s@%d.descriptor", n.localPartialDirectory(), "download", escapeName(fileName), version.Number)
+		_, err := n.fetchFile(owner, Chunk{ID: *FileID, FileName: fileName, Version: version.Number}, localDisk, descriptorPath)
+		if err != nil {
+			continue
+		}
+		descriptor, err := readDescriptor(descriptorPath)
+		os.Remove(descriptorPath)
+		if err != nil {
+			continue
+		}
+		for _, fragment := range descriptor.Fragments {
+			if fragment.Name != "" && !contains(names, fragment.Name) {
+				names = append(names, fragment.Name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,138 @@
+package Chord
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+A file stored with a time to live (StoreFile <path> <ttl>) gets an expiry time in its version. The expiry
+time travels with the version when it is copied or handed over, so every node that has the file deletes it
+at the same time. An expired version is not served anymore, and collectExpired removes it from the disk.
+*/
+
+/*
+parseTTL reads the optional time to live in args[i], e.g. 90s, 10m or 24h. Returns 0 if it is not given.
+*/
+func parseTTL(args []string, i int) (time.Duration, error) {
+	if i >= len(args) {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(args[i])
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("%s is not a time to live, use e.g. 90s, 10m or 24h", args[i])
+	}
+	return ttl, nil
+}
+
+/*
+expiresAt returns the expiry time of a file stored now with the given time to live, zero for no time to live.
+*/
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+/*
+expired returns true if the version has an expiry time before now.
+*/
+func (version Version) expired(now time.Time) bool {
+	return !version.Expires.IsZero() && !version.Expires.After(now)
+}
+
+/*
+expiryText describes when the version expires, for listings.
+*/
+func (version Version) expiryText() string {
+	if version.Expires.IsZero() {
+		return ""
+	}
+	return "  expires " + version.Expires.Format("2006-01-02 15:04:05")
+}
+
+/*
+collectExpired is called periodically. Removes the expired versions of the files in the bucket and of the
+copies. A file in the bucket that has no versions left is deleted, including its copies on the successors.
+*/
+func (n *Node) collectExpired(tex int) {
+	duration := time.Duration(tex) * time.Millisecond
+
+	for {
+		select {
+		case <-n.stopChan:
+			fmt.Println("Stopping expiry collector")
+			return
+		default:
+			time.Sleep(duration)
+			if Debugging {
+				fmt.Printf("\nCollect expired\n")
+			}
+			now := time.Now()
+
+			expired := make(map[string][]string)
+			n.fileLock.Lock()
+			for key, fileNames := range n.Bucket {
+				for _, fileName := range fileNames {
+					if n.expireVersions(n.bucketDirectory(), key, fileName, now) == 0 {
+						n.forgetFiles(n.bucketDirectory(), n.Bucket, key, []string{fileName})
+						expired[key] = append(expired[key], fileName)
+					}
+				}
+			}
+			for key, fileNames := range n.Replicas {
+				for _, fileName := range fileNames {
+					if n.expireVersions(n.replicaDirectory(), key, fileName, now) == 0 {
+						n.deleteReplicaFile(key, fileName)
+					}
+				}
+			}
+			n.fileLock.Unlock()
+
+			//The successors are told without the lock
+			for key, fileNames := range expired {
+				for _, fileName := range fileNames {
+					n.fileDeleted(key, fileName)
+					fmt.Printf("%s has expired and is deleted\n", fileName)
+				}
+			}
+		}
+	}
+}
+
+/*
+expireVersions removes the versions of a filename that expired before now. Returns the number of versions left (-1 if they could
+not be read), when that is 0 the caller removes the name, which also releases the content of the last versions.
+The caller holds n.fileLock.
+*/
+func (n *Node) expireVersions(BucketDirectory string, key string, fileName string, now time.Time) int {
+	versions, err := n.readVersions(BucketDirectory, key, fileName)
+	if err != nil {
+		return -1 //Not known, the file is not touched
+	}
+
+	kept := make([]Version, 0, len(versions))
+	removed := make([]Version, 0)
+	for _, version := range versions {
+		if version.expired(now) {
+			removed = append(removed, version)
+		} else {
+			kept = append(kept, version)
+		}
+	}
+	if len(removed) == 0 || len(kept) == 0 {
+		return len(kept)
+	}
+
+	if CheckError(n.writeVersions(BucketDirectory, key, fileName, kept), "writeVersions in expireVersions") {
+		return len(kept)
+	}
+	for _, version := range removed {
+		n.unrefBlob(version.Hash)
+	}
+	if BucketDirectory == n.bucketDirectory() {
+		n.invalidateCaches(key, fileName)
+	}
+	return len(kept)
+}
@@ -0,0 +1,96 @@
+package Chord
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		args  []string
+		ttl   time.Duration
+		valid bool
+	}{
+		{[]string{"StoreFile", "a.txt"}, 0, true},
+		{[]string{"StoreFile", "a.txt", "90s"}, 90 * time.Second, true},
+		{[]string{"StoreFile", "a.txt", "10m"}, 10 * time.Minute, true},
+		{[]string{"StoreFile", "a.txt", "1h30m"}, 90 * time.Minute, true},
+		{[]string{"StoreFile", "a.txt", "0s"}, 0, false},
+		{[]string{"StoreFile", "a.txt", "-5m"}, 0, false},
+		{[]string{"StoreFile", "a.txt", "10"}, 0, false},
+		{[]string{"StoreFile", "a.txt", "soon"}, 0, false},
+	}
+	for _, test := range tests {
+		ttl, err := parseTTL(test.args, 2)
+		if (err == nil) != test.valid || ttl != test.ttl {
+			t.Errorf("parseTTL(%v) = %s, %v", test.args, ttl, err)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		expires time.Time
+		expired bool
+	}{
+		{time.Time{}, false}, //No time to live
+		{now.Add(time.Second), false},
+		{now, true},
+		{now.Add(-time.Second), true},
+	}
+	for _, test := range tests {
+		if got := (Version{Expires: test.expires}).expired(now); got != test.expired {
+			t.Errorf("expires %s: expired = %t at %s", test.expires, got, now)
+		}
+	}
+
+	if !expiresAt(0).IsZero() || !expiresAt(-time.Second).IsZero() {
+		t.Error("no time to live gave an expiry time")
+	}
+	if at := expiresAt(time.Hour); at.Before(now.Add(time.Hour)) || at.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expiresAt(1h) = %s", at)
+	}
+	if text := (Version{}).expiryText(); text != "" {
+		t.Errorf("expiryText without a time to live = %q", text)
+	}
+	if text := (Version{Expires: now}).expiryText(); !strings.Contains(text, now.Format("2006-01-02 15:04:05")) {
+		t.Errorf("expiryText = %q", text)
+	}
+}
+
+func TestExpireVersions(t *testing.T) {
+	n := &Node{storage: NewMemoryStorage(), Blobs: make(map[string]int)}
+	now := time.Now()
+	versions := []Version{
+		{Number: 1, Hash: strings.Repeat("a", 64), Expires: now.Add(-time.Minute)},
+		{Number: 2, Hash: strings.Repeat("b", 64)},
+		{Number: 3, Hash: strings.Repeat("c", 64), Expires: now.Add(time.Minute)},
+	}
+	for _, version := range versions {
+		n.Blobs[version.Hash] = 1
+	}
+	directory := n.replicaDirectory()
+	if err := n.writeVersions(directory, "7", "f", versions); err != nil {
+		t.Fatal(err)
+	}
+
+	if left := n.expireVersions(directory, "7", "f", now); left != 2 {
+		t.Errorf("%d versions left, want 2", left)
+	}
+	if _, ok := n.Blobs[versions[0].Hash]; ok {
+		t.Error("the content of the expired version is still referenced")
+	}
+	if left := n.expireVersions(directory, "7", "f", now.Add(2*time.Minute)); left != 1 {
+		t.Errorf("%d versions left a minute after version 3 expired, want 1", left)
+	}
+	//The last version is left for the caller, which removes the whole name
+	n.writeVersions(directory, "7", "g", versions[:1])
+	if left := n.expireVersions(directory, "7", "g", now); left != 0 {
+		t.Errorf("%d versions left of a file that expired, want 0", left)
+	}
+	if left := n.expireVersions(directory, "7", "missing", now); left != -1 {
+		t.Errorf("%d versions left of a file that is not stored, want -1", left)
+	}
+}
@@ -0,0 +1,386 @@
+package Chord
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+/*
+FileStorage keeps all names of a node in one file. The file is a log of operations, every record is
+
+	op (1 byte) | length of name (2 bytes) | length of payload (4 bytes) | name | payload | CRC-32 of the record
+
+where the payload is the data of a write or append, or the new name of a rename. The log is replayed when
+the store is opened, which builds an index from every name to the parts of the file holding its data.
+Content is read from the file when it is needed, so it never has to fit in memory. A record that was only
+partly written when the node stopped is cut off. When more than half of the data in the file is old, on
+opening or after a write, the log is rewritten with only the live names, and when nothing is stored anymore
+the file is emptied.
+*/
+type FileStorage struct {
+	mutex   sync.Mutex
+	file    *os.File
+	path    string              //Of the file, compact replaces it
+	end     int64               //Where the next record is written
+	index   map[string][]extent //Name -> the parts of the file with its data, in order
+	garbage int64               //Bytes of data that no name points at anymore
+}
+
+// A part of the data of a name in the store file.
+type extent struct {
+	Offset int64
+	Length int64
+}
+
+const (
+	opWrite byte = iota + 1
+	opAppend
+	opRemove
+	opRemoveAll
+	opRename
+)
+
+const recordHeaderSize = 1 + 2 + 4
+
+/*
+OpenFileStorage opens the store in filePath, creating it if it does not exist.
+*/
+func OpenFileStorage(filePath string) (*FileStorage, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{file: file, path: filePath, index: make(map[string][]extent)}
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if s.garbage > s.live() {
+		if err := s.compact(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+/*
+replay reads all records from the start of the file and applies them to the index.
+A record longer than the rest of the file ends the log without reading it.
+*/
+func (s *FileStorage) replay() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(s.file, 0, 1<<62))
+	header := make([]byte, recordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break //End of the log, or a header that was only partly written
+		}
+		nameLength := int64(binary.BigEndian.Uint16(header[1:3]))
+		payloadLength := int64(binary.BigEndian.Uint32(header[3:7]))
+		if s.end+recordHeaderSize+nameLength+payloadLength+4 > info.Size() {
+			break //Cut off, or a damaged length, which must not allocate up to 4 GiB
+		}
+		body := make([]byte, nameLength+payloadLength+4)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			break
+		}
+		record := append(header, body[:len(body)-4]...)
+		if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(body[len(body)-4:]) {
+			break
+		}
+
+		name := string(body[:nameLength])
+		payloadOffset := s.end + recordHeaderSize + nameLength
+		s.apply(header[0], name, body[nameLength:nameLength+payloadLength], payloadOffset)
+		s.end += recordHeaderSize + nameLength + payloadLength + 4
+		header = make([]byte, recordHeaderSize)
+	}
+
+	if Debugging {
+		fmt.Printf("FileStorage: %d names, %d bytes of log\n", len(s.index), s.end)
+	}
+	return s.file.Truncate(s.end) //Drop a record that was cut off
+}
+
+/*
+apply updates the index with one operation. payloadOffset is where the payload is in the file.
+*/
+func (s *FileStorage) apply(op byte, name string, payload []byte, payloadOffset int64) {
+	switch op {
+	case opWrite:
+		s.drop(name)
+		s.index[name] = []extent{}
+		if len(payload) > 0 {
+			s.index[name] = append(s.index[name], extent{payloadOffset, int64(len(payload))})
+		}
+	case opAppend:
+		if _, ok := s.index[name]; !ok {
+			s.index[name] = []extent{}
+		}
+		if len(payload) > 0 {
+			s.index[name] = append(s.index[name], extent{payloadOffset, int64(len(payload))})
+		}
+	case opRemove:
+		s.drop(name)
+	case opRemoveAll:
+		for stored := range s.index {
+			if isBelow(stored, name) {
+				s.drop(stored)
+			}
+		}
+	case opRename:
+		newName := string(payload)
+		if extents, ok := s.index[name]; ok {
+			delete(s.index, name)
+			s.drop(newName)
+			s.index[newName] = extents
+		}
+	}
+}
+
+/*
+drop removes a name from the index and counts its data as garbage.
+*/
+func (s *FileStorage) drop(name string) {
+	for _, part := range s.index[name] {
+		s.garbage += part.Length
+	}
+	delete(s.index, name)
+}
+
+/*
+live returns the number of bytes of data that names point at.
+*/
+func (s *FileStorage) live() int64 {
+	var total int64
+	for _, extents := range s.index {
+		for _, part := range extents {
+			total += part.Length
+		}
+	}
+	return total
+}
+
+/*
+appendRecord writes a record at the end of the log and applies it to the index. When more than half of the data
+in the log is old, the log is compacted. The record is written even if that fails, the next record tries again.
+*/
+func (s *FileStorage) appendRecord(op byte, name string, payload []byte) error {
+	if len(name) > 0xFFFF {
+		return fmt.Errorf("name too long for the store: %d bytes", len(name))
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(name)+len(payload)+4)
+	record[0] = op
+	binary.BigEndian.PutUint16(record[1:3], uint16(len(name)))
+	binary.BigEndian.PutUint32(record[3:7], uint32(len(payload)))
+	record = append(record, name...)
+	record = append(record, payload...)
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+
+	if _, err := s.file.WriteAt(record, s.end); err != nil {
+		return err
+	}
+	s.apply(op, name, payload, s.end+recordHeaderSize+int64(len(name)))
+	s.end += int64(len(record))
+
+	if len(s.index) == 0 { //Nothing is stored anymore, e.g. after Exit, so the whole log can go
+		s.end, s.garbage = 0, 0
+		return s.file.Truncate(0)
+	}
+	if s.garbage > s.live() {
+		if err := s.compact(); err != nil {
+			fmt.Printf("FileStorage: could not compact %s: %s\n", s.path, err)
+		}
+	}
+	return nil
+}
+
+/*
+compact rewrites the log into a new file with one write record per live name and replaces the old file with it.
+*/
+func (s *FileStorage) compact() error {
+	compactPath := s.path + ".compact"
+	os.Remove(compactPath) //Left by a compaction that was interrupted
+	compacted, err := OpenFileStorage(compactPath)
+	if err != nil {
+		return err
+	}
+	for name := range s.index {
+		content, err := s.readAll(name)
+		if err == nil {
+			err = compacted.appendRecord(opWrite, name, content)
+		}
+		if err == nil {
+			continue
+		}
+		compacted.file.Close()
+		os.Remove(compactPath)
+		return err
+	}
+	err = compacted.file.Sync()
+	if err == nil {
+		err = os.Rename(compactPath, s.path)
+	}
+	if err != nil {
+		compacted.file.Close()
+		os.Remove(compactPath)
+		return err
+	}
+
+	s.file.Close()
+	s.file, s.end, s.index, s.garbage = compacted.file, compacted.end, compacted.index, 0
+	return nil
+}
+
+/*
+readAll reads the whole content of a name, the mutex has to be held.
+*/
+func (s *FileStorage) readAll(name string) ([]byte, error) {
+	extents, ok := s.index[name]
+	if !ok {
+		return nil, notExist("read", name)
+	}
+	content := make([]byte, 0)
+	for _, part := range extents {
+		data := make([]byte, part.Length)
+		if _, err := s.file.ReadAt(data, part.Offset); err != nil {
+			return nil, err
+		}
+		content = append(content, data...)
+	}
+	return content, nil
+}
+
+func (s *FileStorage) names() []string {
+	names := make([]string, 0, len(s.index))
+	for name := range s.index {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *FileStorage) ReadFile(name string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.readAll(path.Clean(name))
+}
+
+func (s *FileStorage) WriteFile(name string, content []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.appendRecord(opWrite, path.Clean(name), content)
+}
+
+func (s *FileStorage) AppendFile(name string, data []byte) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name = path.Clean(name)
+	if err := s.appendRecord(opAppend, name, data); err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, part := range s.index[name] {
+		size += part.Length
+	}
+	return size, nil
+}
+
+func (s *FileStorage) ReadAt(name string, buffer []byte, offset int64) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	extents, ok := s.index[path.Clean(name)]
+	if !ok {
+		return 0, notExist("read", name)
+	}
+
+	read := 0
+	position := int64(0) //Offset in the content where the current extent starts
+	for _, part := range extents {
+		if read == len(buffer) {
+			break
+		}
+		if offset+int64(read) >= position+part.Length {
+			position += part.Length
+			continue
+		}
+		start := offset + int64(read) - position
+		length := part.Length - start
+		if length > int64(len(buffer)-read) {
+			length = int64(len(buffer) - read)
+		}
+		if _, err := s.file.ReadAt(buffer[read:read+int(length)], part.Offset+start); err != nil {
+			return read, err
+		}
+		read += int(length)
+		position += part.Length
+	}
+
+	if read < len(buffer) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (s *FileStorage) Size(name string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	extents, ok := s.index[path.Clean(name)]
+	if !ok {
+		return 0, notExist("stat", name)
+	}
+	var size int64
+	for _, part := range extents {
+		size += part.Length
+	}
+	return size, nil
+}
+
+func (s *FileStorage) Rename(oldName string, newName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index[path.Clean(oldName)]; !ok {
+		return notExist("rename", oldName)
+	}
+	return s.appendRecord(opRename, path.Clean(oldName), []byte(path.Clean(newName)))
+}
+
+func (s *FileStorage) Remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name = path.Clean(name)
+	if _, ok := s.index[name]; ok {
+		return s.appendRecord(opRemove, name, nil)
+	}
+	if len(childNames(s.names(), name)) > 0 {
+		return fmt.Errorf("remove %s: directory not empty", name)
+	}
+	return nil //Like an empty directory
+}
+
+func (s *FileStorage) RemoveAll(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.appendRecord(opRemoveAll, path.Clean(name), nil)
+}
+
+func (s *FileStorage) ReadDir(name string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	children := childNames(s.names(), name)
+	if len(children) == 0 {
+		return nil, notExist("readdir", name)
+	}
+	return children, nil
+}
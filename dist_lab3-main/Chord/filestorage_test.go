@@ -0,0 +1,223 @@
+package Chord
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+reopen closes a FileStorage and opens its file again, so the log is replayed.
+*/
+func reopen(t *testing.T, s *FileStorage, filePath string) *FileStorage {
+	s.file.Close()
+	reopened, err := OpenFileStorage(filePath)
+	if err != nil {
+		t.Fatalf("reopen: %s", err)
+	}
+	t.Cleanup(func() { reopened.file.Close() })
+	return reopened
+}
+
+/*
+storedNames returns every name in s with its content.
+*/
+func storedNames(t *testing.T, s *FileStorage) map[string]string {
+	contents := make(map[string]string)
+	for _, name := range s.names() {
+		content, err := s.ReadFile(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		contents[name] = string(content)
+	}
+	return contents
+}
+
+/*
+storeFileSize returns the size of the store file.
+*/
+func storeFileSize(t *testing.T, filePath string) int64 {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "store.db")
+	s, err := OpenFileStorage(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WriteFile("bucket/1/a", []byte("old"))
+	s.WriteFile("bucket/1/a", []byte("new"))
+	s.AppendFile("partial/b", []byte("abc"))
+	s.AppendFile("partial/b", []byte("def"))
+	s.WriteFile("bucket/2/c", []byte("gone"))
+	s.Remove("bucket/2/c")
+	s.WriteFile("bucket/3/d", []byte("moved"))
+	s.Rename("bucket/3/d", "bucket/4/d")
+	s.WriteFile("tmp/x", []byte("x"))
+	s.WriteFile("tmp/y/z", []byte("z"))
+	s.RemoveAll("tmp")
+	s.WriteFile("bucket/5/empty", nil)
+	want := storedNames(t, s)
+
+	s = reopen(t, s, filePath)
+	if got := storedNames(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopen %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(want, map[string]string{"bucket/1/a": "new", "partial/b": "abcdef", "bucket/4/d": "moved", "bucket/5/empty": ""}) {
+		t.Errorf("stored %v", want)
+	}
+
+	//Removing the last name empties the file
+	s.RemoveAll("bucket")
+	s.RemoveAll("partial")
+	if size := storeFileSize(t, filePath); size != 0 {
+		t.Errorf("the store file has %d bytes with nothing stored", size)
+	}
+}
+
+func TestFileStorageTornRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(file *os.File, size int64) //Damages the last record of a file of size bytes
+	}{
+		{"cut in the CRC", func(file *os.File, size int64) { file.Truncate(size - 1) }},
+		{"cut in the payload", func(file *os.File, size int64) { file.Truncate(size - 6) }},
+		{"cut in the header", func(file *os.File, size int64) { file.Truncate(size - int64(len("bucket/2/b")+len("second")+4+3)) }},
+		{"corrupted payload", func(file *os.File, size int64) { file.WriteAt([]byte("X"), size-6) }},
+		{"corrupted length", func(file *os.File, size int64) {
+			file.WriteAt([]byte{0xff}, size-int64(len("bucket/2/b")+len("second")+4+recordHeaderSize-3))
+		}},
+	}
+	for _, test := range tests {
+		filePath := filepath.Join(t.TempDir(), "store.db")
+		s, err := OpenFileStorage(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.WriteFile("bucket/1/a", []byte("first"))
+		intact := storeFileSize(t, filePath)
+		s.WriteFile("bucket/2/b", []byte("second"))
+		test.damage(s.file, storeFileSize(t, filePath))
+
+		s = reopen(t, s, filePath)
+		if got := storedNames(t, s); !reflect.DeepEqual(got, map[string]string{"bucket/1/a": "first"}) {
+			t.Errorf("%s: stored %v after reopen", test.name, got)
+		}
+		if size := storeFileSize(t, filePath); size != intact {
+			t.Errorf("%s: %d bytes after reopen, want the damaged record cut off at %d", test.name, size, intact)
+		}
+
+		//New records follow the intact ones and are replayed
+		s.WriteFile("bucket/3/c", []byte("third"))
+		s = reopen(t, s, filePath)
+		if got := storedNames(t, s); !reflect.DeepEqual(got, map[string]string{"bucket/1/a": "first", "bucket/3/c": "third"}) {
+			t.Errorf("%s: stored %v after writing on", test.name, got)
+		}
+	}
+}
+
+/*
+liveSize returns the size of a log with one write record for every name in contents.
+*/
+func liveSize(contents map[string]string) int64 {
+	var size int64
+	for name, content := range contents {
+		size += int64(recordHeaderSize + len(name) + len(content) + 4)
+	}
+	return size
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "store.db")
+	s, err := OpenFileStorage(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AppendFile("partial/c", []byte("part one,"))
+	s.AppendFile("partial/c", []byte("part two"))
+
+	//A running store compacts when more than half of the data is old, so overwrites do not grow the file
+	for i := 0; i < 100; i++ {
+		s.WriteFile("bucket/1/a", []byte(fmt.Sprintf("version %3d of a", i)))
+		s.WriteFile("bucket/2/b", []byte("dropped"))
+		s.Remove("bucket/2/b")
+		if size, bound := storeFileSize(t, filePath), 4*liveSize(storedNames(t, s)); size > bound {
+			t.Fatalf("%d bytes after %d overwrites, more than %d", size, i+1, bound)
+		}
+	}
+	want := map[string]string{"bucket/1/a": "version  99 of a", "partial/c": "part one,part two"}
+	if got := storedNames(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after compaction %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filePath + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("the compaction file was left: %v", err)
+	}
+
+	//The compacted log is replayed like any other
+	s = reopen(t, s, filePath)
+	if got := storedNames(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopening the compacted store %v, want %v", got, want)
+	}
+
+	//A compaction that cannot replace the file removes its own and leaves the store as it was
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	os.MkdirAll(filepath.Join(blocked, "in the way"), 0700)
+	s.path = blocked
+	if err := s.compact(); err == nil {
+		t.Error("compacted onto a directory")
+	}
+	if _, err := os.Stat(blocked + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("the compaction file was left after a failed rename: %v", err)
+	}
+	if got := storedNames(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after a failed compaction %v, want %v", got, want)
+	}
+}
+
+func TestFileStorageCompactionOnOpen(t *testing.T) {
+	//A log that is mostly old data, as left by a node that stopped before it compacted
+	var log []byte
+	for i := 0; i < 10; i++ {
+		log = append(log, logRecord(opWrite, "bucket/1/a", "version of a")...)
+		log = append(log, logRecord(opWrite, "bucket/2/b", "dropped")...)
+		log = append(log, logRecord(opRemove, "bucket/2/b", "")...)
+	}
+	filePath := filepath.Join(t.TempDir(), "store.db")
+	if err := os.WriteFile(filePath, log, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFileStorage(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.file.Close() })
+	want := map[string]string{"bucket/1/a": "version of a"}
+	if got := storedNames(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("opened %v, want %v", got, want)
+	}
+	if size := storeFileSize(t, filePath); size != liveSize(want) {
+		t.Errorf("%d bytes after opening, want the %d of one record per live name", size, liveSize(want))
+	}
+}
+
+/*
+logRecord encodes one record of the store file.
+*/
+func logRecord(op byte, name string, payload string) []byte {
+	record := []byte{op}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(name)))
+	record = binary.BigEndian.AppendUint32(record, uint32(len(payload)))
+	record = append(append(record, name...), payload...)
+	return binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+}
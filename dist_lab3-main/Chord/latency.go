@@ -0,0 +1,182 @@
+package Chord
+
+import (
+	"math/big"
+	"sync"
+	"time"
+)
+
+/*
+Fingers are chosen by latency (proximity neighbour selection). Any node whose ID lies in [n + 2^(i-1), n + 2^i) is a
+valid entry i of the finger table, a hop through it leaves less than 2^(i-1) to the key just like the exact successor
+of n + 2^(i-1) does. fix_fingers finds that successor as before, takes the entries of its successor list that are in
+the interval as further candidates, and keeps the one with the lowest round trip.
+
+The round trips are measured while stabilizing: stabilize and check_predecessor time their calls to the successor and
+predecessor, fix_fingers times the call for the successor list and pings a candidate whose measurement is older than
+LatencyMaxAge. closestPrecedingNode routes as before, to the known node closest before the key, so every hop still
+gets closer to the key.
+*/
+
+// How long a measured round trip is used before fix_fingers measures it again.
+const LatencyMaxAge = 30 * time.Second
+
+// How much faster another candidate must be to replace a finger that is still in its interval, so fingers do not flap on jitter.
+const LatencyMargin = 500 * time.Microsecond
+
+// A smoothed round trip to a node.
+type rttSample struct {
+	rtt      time.Duration
+	measured time.Time
+}
+
+// The round trips of a node to other nodes. Safe for use by several goroutines at once.
+type latencies struct {
+	mutex   sync.Mutex
+	samples map[string]rttSample
+}
+
+func newLatencies() *latencies {
+	return &latencies{samples: make(map[string]rttSample)}
+}
+
+/*
+record adds a measured round trip to address. It is smoothed like TCP does, 7/8 of the old value and 1/8 of the new.
+*/
+func (l *latencies) record(address string, rtt time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if sample, ok := l.samples[address]; ok {
+		rtt = (7*sample.rtt + rtt) / 8
+	}
+	l.samples[address] = rttSample{rtt: rtt, measured: time.Now()}
+}
+
+/*
+get returns the smoothed round trip to address, false if it was not measured within LatencyMaxAge.
+*/
+func (l *latencies) get(address string) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	sample, ok := l.samples[address]
+	if !ok || time.Since(sample.measured) > LatencyMaxAge {
+		return 0, false
+	}
+	return sample.rtt, true
+}
+
+/*
+forget removes the round trip to address, after a call to it failed.
+*/
+func (l *latencies) forget(address string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.samples, address)
+}
+
+/*
+timedCall is call with the time limit CallTimeout, it records the round trip to address if the call succeeds.
+It is used for the calls that keep the ring up, so a node that hangs does not stall stabilize or fix_fingers.
+*/
+func (n *Node) timedCall(rpcname string, args interface{}, reply interface{}, address string) bool {
+	sent := time.Now()
+	if !n.callTimeout(rpcname, args, reply, address, CallTimeout) {
+		n.latency.forget(address)
+		return false
+	}
+	n.latency.record(address, time.Since(sent))
+	return true
+}
+
+/*
+roundTrip returns the round trip to address, and pings it first if the last measurement is older than LatencyMaxAge.
+Returns false if the node does not answer.
+*/
+func (n *Node) roundTrip(address string) (time.Duration, bool) {
+	if rtt, ok := n.latency.get(address); ok {
+		return rtt, true
+	}
+	SenderArgs := SendArgs{CheckSucORPredFail: true}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.timedCall("Node.CallHandler", &SenderArgs, &ReceiveArgs, address) {
+		return 0, false
+	}
+	return n.latency.get(address)
+}
+
+/*
+rttText returns the round trip to address for PrintDetails, "?" if it has not been measured lately.
+*/
+func (n *Node) rttText(address string) string {
+	if rtt, ok := n.latency.get(address); ok {
+		return rtt.Round(time.Microsecond).String()
+	}
+	return "?"
+}
+
+/*
+inFingerInterval returns true if id is a valid entry next (1 to M) of the finger table, in [n + 2^(next-1), n + 2^next).
+*/
+func (n *Node) inFingerInterval(id *big.Int, next int) bool {
+	start, end := n.jump(n.Id, next), n.jump(n.Id, next+1) //For next = M the interval ends at n
+	return id.Cmp(start) == 0 || between(start, id, end, false)
+}
+
+/*
+nodeInFingerInterval returns true if the node on address is a valid entry next of the finger table.
+*/
+func (n *Node) nodeInFingerInterval(address string, next int) bool {
+	id := n.peerID(address)
+	return id != nil && n.inFingerInterval(id, next)
+}
+
+/*
+chooseFinger returns the node for entry next (1 to M) of the finger table, given suc, the successor of the start of
+its interval. The candidates are suc, the entries of its successor list in the interval and the current finger if it
+is still in the interval. The current finger is kept unless another candidate is faster by LatencyMargin, otherwise
+the fastest candidate that answers is chosen. If no node is in the interval, the finger is suc like in plain Chord.
+*/
+func (n *Node) chooseFinger(next int, suc string) string {
+	if id := n.peerID(suc); id == nil || !n.inFingerInterval(id, next) {
+		return suc
+	}
+
+	candidates := []string{suc}
+	SenderArgs := SendArgs{GetSuccessorListRequest: true}
+	ReceiveArgs := ReceiveArgs{}
+	if n.timedCall("Node.CallHandler", &SenderArgs, &ReceiveArgs, suc) {
+		for _, address := range ReceiveArgs.SuccessorList {
+			if address != "" && address != n.Address && !contains(candidates, address) && n.nodeInFingerInterval(address, next) {
+				candidates = append(candidates, address)
+			}
+		}
+	}
+	current := n.fingers()[next-1]
+	if current != "" && current != n.Address && !contains(candidates, current) && n.nodeInFingerInterval(current, next) {
+		candidates = append(candidates, current)
+	}
+
+	best, bestRTT := "", time.Duration(0)
+	currentRTT, currentAlive := time.Duration(0), false
+	for _, address := range candidates {
+		rtt, ok := n.roundTrip(address)
+		if !ok {
+			continue
+		}
+		if address == current {
+			currentRTT, currentAlive = rtt, true
+		}
+		if best == "" || rtt < bestRTT {
+			best, bestRTT = address, rtt
+		}
+	}
+	if best == "" {
+		return suc
+	}
+	if currentAlive && bestRTT+LatencyMargin > currentRTT {
+		return current
+	}
+	return best
+}
@@ -0,0 +1,166 @@
+package Chord
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/rpc"
+	"path"
+	"sort"
+	"time"
+)
+
+// How long callTimeout waits for an answer, e.g. before ListAll counts a node as down or stabilize gives up on it.
+const CallTimeout = 2 * time.Second
+
+// How long ListAll walks the ring at most. The nodes it has not reached by then are not listed.
+const ListAllTimeout = 30 * time.Second
+
+// A file found by ListAll, and the node that is responsible for it.
+type RingFile struct {
+	Node string
+	FileInfo
+}
+
+/*
+ListAll, Takes a pattern (empty for all files). Walks the ring from the current node through the successor lists,
+asks every node for the files in its bucket, and prints the files whose name, or last path element, matches the
+pattern (path.Match syntax, e.g. *.txt). A node that does not answer within CallTimeout is skipped and the walk
+continues with the next node in the successor list it was found in. The walk stops after ListAllTimeout, so a ring
+that changes while it is walked cannot keep it going. Fragments and values stored with Put are not listed.
+*/
+func (n *Node) ListAll(pattern string) []RingFile {
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Printf("ListAll failed: %s is not a valid pattern: %s\n", pattern, err)
+			return nil
+		}
+	}
+
+	found := make([]RingFile, 0)
+	down := make([]string, 0)
+	visited := make(map[string]bool)
+	candidates := []string{n.Address}
+	nodes := 0
+	deadline := time.Now().Add(ListAllTimeout)
+	stopped := false
+
+	for len(candidates) > 0 {
+		address := candidates[0]
+		candidates = candidates[1:]
+		if address == "" || visited[address] {
+			continue //Back where the walk started, or at a node that has been listed
+		}
+		timeout := min(CallTimeout, time.Until(deadline))
+		if timeout <= 0 {
+			stopped = true
+			break
+		}
+		visited[address] = true
+
+		SenderArgs := SendArgs{ListFilesRequest: true}
+		FilesReply := ReceiveArgs{}
+		if !n.callTimeout("Node.CallHandler", &SenderArgs, &FilesReply, address, timeout) {
+			down = append(down, address)
+			continue //The rest of the successor list it was found in is tried next
+		}
+		nodes++
+		for _, file := range FilesReply.Files {
+			if isFragmentName(file.FileName) || isValueName(file.FileName) {
+				continue
+			}
+			if matchesPattern(pattern, file.FileName) {
+				found = append(found, RingFile{Node: address, FileInfo: file})
+			}
+		}
+
+		SenderArgs = SendArgs{GetSuccessorListRequest: true}
+		SuccessorsReply := ReceiveArgs{}
+		if n.callTimeout("Node.CallHandler", &SenderArgs, &SuccessorsReply, address, min(CallTimeout, time.Until(deadline))) {
+			candidates = SuccessorsReply.SuccessorList
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].FileName < found[j].FileName })
+	fmt.Printf("Files in the ring matching %q: %d (on %d nodes)\n", pattern, len(found), nodes)
+	for _, file := range found {
+		fmt.Printf("  %-32s %10d bytes  v%d  key %-6s at %s%s\n", file.FileName, file.Latest.Size, file.Latest.Number, file.Key, file.Node, file.Latest.expiryText())
+	}
+	for _, address := range down {
+		fmt.Printf("  %s did not answer, its files are not listed\n", address)
+	}
+	if stopped {
+		fmt.Printf("  The walk stopped after %s, the nodes after the last one listed are missing\n", ListAllTimeout)
+	}
+	return found
+}
+
+/*
+matchesPattern returns true if fileName or its last path element matches pattern. An empty pattern matches everything.
+*/
+func matchesPattern(pattern string, fileName string) bool {
+	if pattern == "" {
+		return true
+	}
+	if matched, _ := path.Match(pattern, fileName); matched {
+		return true
+	}
+	matched, _ := path.Match(pattern, path.Base(fileName))
+	return matched
+}
+
+/*
+callTimeout is call with a time limit. Returns false if the node on address does not answer within timeout,
+the reply must not be used then. The deadline is set on the connection, so dialing, the HTTP handshake and the
+call itself all stop when it passes and nothing is left waiting for the node.
+*/
+func (n *Node) callTimeout(rpcname string, args interface{}, reply interface{}, address string, timeout time.Duration) bool {
+	c, err := dialTimeout(address, timeout)
+	if CheckError(err, "During dialTimeout, in callTimeout") {
+		return false
+	}
+	defer c.Close()
+
+	err = c.Call(rpcname, args, reply)
+	if err == nil {
+		if receiveArgs, ok := reply.(*ReceiveArgs); ok && receiveArgs != nil {
+			n.learnIDs(address, receiveArgs)
+		}
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		fmt.Printf("No answer from %s within %s\n", address, timeout)
+	} else {
+		fmt.Println(err)
+	}
+	return false
+}
+
+/*
+dialTimeout is rpc.DialHTTP with a deadline for the whole connection, which is used for one call.
+*/
+func dialTimeout(address string, timeout time.Duration) (*rpc.Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	//The handshake of rpc.DialHTTP
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.0\n\n", rpc.DefaultRPCPath)
+	response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && response.Status != "200 Connected to Go RPC" {
+		err = fmt.Errorf("unexpected HTTP response: %s", response.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
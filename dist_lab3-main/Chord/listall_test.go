@@ -0,0 +1,119 @@
+package Chord
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchesPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		fileName string
+		matches  bool
+	}{
+		{"", "anything", true},
+		{"*.txt", "notes.txt", true},
+		{"*.txt", "notes.txt.bak", false},
+		{"*.txt", "dir/notes.txt", true}, //The last path element matches
+		{"dir/*", "dir/notes.txt", true},
+		{"dir/*", "other/notes.txt", false},
+		{"*", "dir/notes.txt", true},
+		{"note?.txt", "notes.txt", true},
+		{"[a-c]*", "beta", true},
+		{"[a-c]*", "delta", false},
+		{"notes.txt", "notes.txt", true},
+		{"[", "notes.txt", false}, //Not a valid pattern, ListAll refuses it before
+	}
+	for _, test := range tests {
+		if got := matchesPattern(test.pattern, test.fileName); got != test.matches {
+			t.Errorf("matchesPattern(%q, %q) = %t", test.pattern, test.fileName, got)
+		}
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	//A node that accepts the connection and never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn) //Reads the request and never answers
+		}
+	}()
+
+	n := &Node{peerIDs: make(map[string]big.Int)}
+	started := time.Now()
+	if n.callTimeout("Node.CallHandler", &SendArgs{CheckSucORPredFail: true}, &ReceiveArgs{}, listener.Addr().String(), 200*time.Millisecond) {
+		t.Error("a call to a node that does not answer succeeded")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("callTimeout returned after %s with a timeout of 200ms", elapsed)
+	}
+
+	node := startTestNode(t, nil)
+	reply := ReceiveArgs{}
+	if !n.callTimeout("Node.CallHandler", &SendArgs{CheckSucORPredFail: true}, &reply, node.Address, CallTimeout) {
+		t.Fatal("a call to a running node failed")
+	}
+	if id := n.knownID(node.Address); id == nil || id.Cmp(&node.Id) != 0 {
+		t.Errorf("the ID of the node was not learned from the reply: %v", id)
+	}
+}
+
+func TestRingListAll(t *testing.T) {
+	nodes := startRing(t, 3)
+	directory := t.TempDir()
+	for i, fileName := range []string{"a.txt", "b.txt", "c.log", "dir/d.txt"} {
+		filePath := filepath.Join(directory, fmt.Sprint(i))
+		if err := os.WriteFile(filePath, []byte(fileName), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !nodes[i%len(nodes)].storeAs(filePath, fileName, 0) {
+			t.Fatalf("storing %s failed", fileName)
+		}
+	}
+	if err := nodes[0].Put("value", []byte("not listed")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range nodes {
+		found := n.ListAll("*.txt")
+		names := make([]string, 0, len(found))
+		for _, file := range found {
+			names = append(names, file.FileName)
+		}
+		if fmt.Sprint(names) != "[a.txt b.txt dir/d.txt]" {
+			t.Errorf("ListAll through %s found %v", n.Address, names)
+		}
+	}
+
+	//A node that is down is reported and the walk goes on past it, to the next node in the successor list.
+	//The files the other nodes hold are still listed.
+	waitFor(t, "successor lists past the stopped node", func() bool {
+		return contains(nodes[0].successorList(), nodes[1].Address) && contains(nodes[0].successorList(), nodes[2].Address)
+	})
+	stopped := nodes[1]
+	want := 0
+	for _, file := range nodes[0].ListAll("") {
+		if file.Node != stopped.Address {
+			want++
+		}
+	}
+	stopTestNode(stopped)
+	if found := nodes[0].ListAll(""); len(found) != want {
+		t.Errorf("ListAll found %d files after %s stopped, want the %d on the other nodes", len(found), stopped.Address, want)
+	}
+}
@@ -0,0 +1,173 @@
+package Chord
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+/*
+A lookup finds the node responsible for an ID, in one of two modes chosen with --lookup or per call:
+
+	iterative - the node that asks calls every hop itself with a GetSuccessorRequest, one round trip from it per hop
+	recursive - the node that asks calls the start node only. Every node forwards the query to its closest preceding
+	            node, and the answer of the node whose successor is responsible travels back along the same path
+
+Both modes take at most maxSteps hops, report the same errors and return the path they took, also when they fail.
+*/
+
+const (
+	LookupIterative = "iterative"
+	LookupRecursive = "recursive"
+)
+
+// The values of --lookup.
+var LookupModes = []string{LookupIterative, LookupRecursive}
+
+// Where a node found the address it answered a lookup with.
+const (
+	ViaSuccessor = "successor" //An entry of its successor list
+	ViaFinger    = "finger"    //An entry of its finger table
+	ViaSelf      = "self"      //No closer node is known, the node answered with itself
+)
+
+// One node a lookup went through.
+type Hop struct {
+	Address string
+	ID      big.Int
+	Answer  string        //The next hop, or on the last hop the node responsible for the ID
+	Via     string        //Where the node found Answer
+	RTT     time.Duration //Round trip of the call to the node, without the time it waited for later hops
+}
+
+// The result of a lookup, see LookupRoute.
+type Route struct {
+	Key     big.Int
+	Owner   string //The node responsible for Key, empty if the lookup failed
+	Mode    string
+	Hops    []Hop
+	Elapsed time.Duration
+}
+
+/*
+forgetNode drops a node that a lookup could not reach from the finger table and forgets its round trip, so the
+next lookup and fix_fingers do not route through it again. The successor list is repaired by stabilize.
+*/
+func (n *Node) forgetNode(address string) {
+	n.forgetFinger(address)
+	n.latency.forget(address)
+}
+
+/*
+unreachableHop is the error of a lookup that could not call the node on address.
+*/
+func unreachableHop(address string) error {
+	return fmt.Errorf("lookup could not reach %s", address)
+}
+
+/*
+maxStepsReached is the error of a lookup that did not find the responsible node within maxSteps hops.
+*/
+func maxStepsReached(maxSteps int) error {
+	return fmt.Errorf("node address not found, max steps (%d) reached", maxSteps)
+}
+
+/*
+lookup finds the node responsible for id in the given mode, beginning at the node start. An empty mode is iterative.
+Returns the responsible node and the hops the lookup went through.
+*/
+func (n *Node) lookup(id big.Int, start string, maxSteps int, mode string) (string, []Hop, error) {
+	if mode == LookupRecursive {
+		return n.findRecursive(id, start, maxSteps)
+	}
+	return n.findIterative(id, start, maxSteps)
+}
+
+/*
+LookupRoute looks up key like Lookup, in the given mode (empty for --lookup), without printing anything.
+Returns the route with every hop, and the error if the responsible node was not found.
+*/
+func (n *Node) LookupRoute(Key big.Int, mode string) (Route, error) {
+	if mode == "" {
+		mode = n.Flags.Lookup
+	}
+	if mode == "" {
+		mode = LookupIterative
+	}
+	if !contains(LookupModes, mode) {
+		return Route{Key: Key, Mode: mode}, fmt.Errorf("unknown lookup mode %s, use one of %v", mode, LookupModes)
+	}
+
+	start := time.Now()
+	owner, hops, err := n.lookup(Key, n.Address, MaxSteps, mode)
+	return Route{Key: Key, Owner: owner, Mode: mode, Hops: hops, Elapsed: time.Since(start)}, err
+}
+
+/*
+findRecursive hands the lookup to start, which routes it on through routeRecursive.
+*/
+func (n *Node) findRecursive(id big.Int, start string, maxSteps int) (string, []Hop, error) {
+	SenderArgs := SendArgs{RecursiveLookupRequest: true, SendArg: id, Steps: maxSteps}
+	ReceiveArgs := ReceiveArgs{}
+	sent := time.Now()
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, start) {
+		return "", nil, unreachableHop(start)
+	}
+	path := ReceiveArgs.Path
+	if len(path) > 0 {
+		path[0].RTT = time.Since(sent) - ReceiveArgs.Forwarding
+	}
+
+	if ReceiveArgs.Answer {
+		return ReceiveArgs.FindSuccessorAnswer.Address, path, nil
+	}
+	if ReceiveArgs.Refused {
+		return "", path, fmt.Errorf("lookup %s", ReceiveArgs.ReplyArgs)
+	}
+	if ReceiveArgs.ReplyArgs != "" {
+		return "", path, unreachableHop(ReceiveArgs.ReplyArgs)
+	}
+	return "", path, maxStepsReached(maxSteps)
+}
+
+/*
+routeRecursive answers a recursive lookup for id that may take steps more hops, including this one. If the successor is
+responsible for id it is the answer, otherwise the query is forwarded to the closest preceding node. On failure the
+answer is false, with the address of the hop that could not be reached in ReplyArgs, or empty when the steps ran out.
+A hop that refused the query is passed on with Refused set. The path starts with this node, the caller fills in its RTT.
+*/
+func (n *Node) routeRecursive(id big.Int, steps int, receiveArgs *ReceiveArgs) {
+	found, next, via := n.findSuccessor(id)
+	receiveArgs.Path = []Hop{{Address: n.Address, ID: n.Id, Answer: next, Via: via}}
+	if found {
+		receiveArgs.FindSuccessorAnswer = FindSuccessorAnswer{IsSuccessor: true, Address: next, Via: via, ID: n.Id}
+		receiveArgs.PeerIDs = n.knownIDs(next)
+		receiveArgs.Answer = true
+		return
+	}
+	if steps <= 1 {
+		receiveArgs.Answer = false
+		return
+	}
+
+	SenderArgs := SendArgs{RecursiveLookupRequest: true, SendArg: id, Steps: steps - 1}
+	ReceiveArgs := ReceiveArgs{}
+	sent := time.Now()
+	ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, next)
+	receiveArgs.Forwarding = time.Since(sent)
+	if !ok {
+		n.forgetNode(next)
+		receiveArgs.ReplyArgs = next
+		receiveArgs.Answer = false
+		return
+	}
+	if len(ReceiveArgs.Path) > 0 {
+		ReceiveArgs.Path[0].RTT = receiveArgs.Forwarding - ReceiveArgs.Forwarding
+	}
+	receiveArgs.Path = append(receiveArgs.Path, ReceiveArgs.Path...)
+	receiveArgs.FindSuccessorAnswer = ReceiveArgs.FindSuccessorAnswer
+	receiveArgs.PeerIDs = ReceiveArgs.PeerIDs
+	receiveArgs.ReplyArgs = ReceiveArgs.ReplyArgs
+	receiveArgs.Refused = ReceiveArgs.Refused
+	receiveArgs.Answer = ReceiveArgs.Answer
+}
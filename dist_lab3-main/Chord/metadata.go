@@ -0,0 +1,103 @@
+package Chord
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+)
+
+// Metadata of a stored file: the newest version and how many versions are kept.
+type FileInfo struct {
+	Key      string
+	FileName string
+	Latest   Version
+	Versions int
+}
+
+/*
+detectMimeType guesses the MIME type of a file from the extension of its name, or else from the start of its content.
+*/
+func detectMimeType(fileName string, head []byte) string {
+	if mimeType := mime.TypeByExtension(path.Ext(fileName)); mimeType != "" {
+		return mimeType
+	}
+	return http.DetectContentType(head)
+}
+
+/*
+detectFileMimeType guesses the MIME type of the file on filePath, reading at most the first 512 bytes.
+*/
+func detectFileMimeType(filePath string) string {
+	head := make([]byte, 512)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return detectMimeType(filePath, nil)
+	}
+	defer file.Close()
+	read, _ := io.ReadFull(file, head)
+	return detectMimeType(filePath, head[:read])
+}
+
+/*
+uploaderID is the name stored as uploader of the files this node stores: its UserID, or its address without one.
+*/
+func (n *Node) uploaderID() string {
+	if n.Flags.UserID != "" {
+		return n.Flags.UserID
+	}
+	return n.Address
+}
+
+/*
+ListFiles, Takes a node address, or empty for the current node. Prints the files in the bucket of that node
+with the metadata of their newest version.
+*/
+func (n *Node) ListFiles(address string) []FileInfo {
+	if address == "" {
+		address = n.Address
+	}
+
+	SenderArgs := SendArgs{ListFilesRequest: true}
+	ReceiveArgs := ReceiveArgs{}
+	ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address)
+
+	if !ok {
+		fmt.Printf("Error during call in ListFiles\n")
+		return nil
+	}
+
+	fmt.Printf("Files stored at %s: %d\n", address, len(ReceiveArgs.Files))
+	for _, file := range ReceiveArgs.Files {
+		latest := file.Latest
+		if latest.Erasure {
+			latest.MimeType += " (erasure coded)"
+		}
+		fmt.Printf("  %-24s %10d bytes  %-24s  %s  by %s  v%d (%d kept)  %s  key %s%s\n", file.FileName, latest.Size, latest.MimeType,
+			latest.Time.Format("2006-01-02 15:04:05"), latest.Uploader, latest.Number, file.Versions, latest.Hash[:12], file.Key, latest.expiryText())
+	}
+	return ReceiveArgs.Files
+}
+
+/*
+listFiles returns the metadata of all files in the node's bucket, sorted by filename.
+*/
+func (n *Node) listFiles() []FileInfo {
+	files := make([]FileInfo, 0)
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+	for key, fileNames := range n.Bucket {
+		for _, fileName := range fileNames {
+			versions, err := n.readVersions(n.bucketDirectory(), key, fileName)
+			if CheckError(err, "readVersions in listFiles") {
+				continue
+			}
+			files = append(files, FileInfo{Key: key, FileName: fileName, Latest: versions[len(versions)-1], Versions: len(versions)})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
+	return files
+}
@@ -0,0 +1,94 @@
+package Chord
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDetectMimeType(t *testing.T) {
+	tests := []struct {
+		fileName string
+		head     string
+		mimeType string
+	}{
+		{"page.html", "", "text/html; charset=utf-8"},
+		{"data.json", "not json", "application/json"}, //The extension wins over the content
+		{"dir/picture.PNG", "", "image/png"},
+		{"notes", "plain text", "text/plain; charset=utf-8"},
+		{"picture", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		{"empty", "", "text/plain; charset=utf-8"},
+		{"binary", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		if mimeType := detectMimeType(test.fileName, []byte(test.head)); mimeType != test.mimeType {
+			t.Errorf("detectMimeType(%q, %q) = %q, want %q", test.fileName, test.head, mimeType, test.mimeType)
+		}
+	}
+}
+
+func TestRingListFiles(t *testing.T) {
+	nodes := startPlacedRing(t, []int64{1000, 40000})
+	nodes = append(nodes, startTestNode(t, nodes[0])) //Without -i, its address is stored as uploader
+	waitFor(t, "a stable ring", func() bool { return ringStable(nodes) })
+
+	tests := []struct {
+		fileName string
+		contents []string //Content of every version stored, the last one is listed
+		uploader int      //The node that stores the last version
+		mimeType string
+	}{
+		{"page.html", []string{"<html></html>"}, 0, "text/html; charset=utf-8"},
+		{"data.json", []string{`{"v": 1}`, `{"v": 2, "more": true}`}, 1, "application/json"},
+		{"dir/notes", []string{"plain text"}, 2, "text/plain; charset=utf-8"},
+		{"picture", []string{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"}, 2, "image/png"},
+	}
+	source := t.TempDir()
+	before := time.Now()
+	for _, test := range tests {
+		for i, content := range test.contents {
+			filePath := filepath.Join(source, filepath.Base(test.fileName))
+			if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			uploader := nodes[(test.uploader+len(test.contents)-1-i)%len(nodes)] //The earlier versions by other nodes
+			if !uploader.storeAs(filePath, test.fileName, 0) {
+				t.Fatalf("StoreFile %s failed", test.fileName)
+			}
+		}
+	}
+	after := time.Now()
+
+	listed := make(map[string]FileInfo)
+	for _, n := range nodes {
+		for _, file := range nodes[0].ListFiles(n.Address) {
+			listed[file.FileName] = file
+		}
+	}
+	if len(listed) != len(tests) {
+		t.Errorf("ListFiles listed %d files, want %d", len(listed), len(tests))
+	}
+	for _, test := range tests {
+		file, ok := listed[test.fileName]
+		if !ok {
+			t.Errorf("%s is not listed", test.fileName)
+			continue
+		}
+		content := test.contents[len(test.contents)-1]
+		latest := file.Latest
+		uploader := nodes[test.uploader].uploaderID()
+		if file.Key != hashModulo(Hash(test.fileName), nodes[0].M2).String() || file.Versions != len(test.contents) || latest.Number != len(test.contents) {
+			t.Errorf("%s is listed under key %s with %d versions, the latest %d", test.fileName, file.Key, file.Versions, latest.Number)
+		}
+		if latest.Size != int64(len(content)) || latest.Hash != checksum([]byte(content)) {
+			t.Errorf("%s is listed with size %d and hash %s, want %d and %s", test.fileName, latest.Size, latest.Hash, len(content), checksum([]byte(content)))
+		}
+		if latest.Uploader != uploader || latest.MimeType != test.mimeType {
+			t.Errorf("%s is listed as %q uploaded by %q, want %q by %q", test.fileName, latest.MimeType, latest.Uploader, test.mimeType, uploader)
+		}
+		if latest.Time.Before(before) || latest.Time.After(after) {
+			t.Errorf("%s is listed as uploaded at %s, it was stored between %s and %s", test.fileName, latest.Time, before, after)
+		}
+	}
+}
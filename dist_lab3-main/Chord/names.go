@@ -0,0 +1,215 @@
+package Chord
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Filenames and keys arrive over the network and end up in paths on disk, so they are checked in CallHandler before
+any request touches the storage, and a filename is always encoded into a single harmless path element.
+*/
+
+// The longest filename that is accepted, in bytes after escapeName. Leaves room for the suffixes of partial files.
+const MaxNameLength = 200
+
+/*
+escapeName makes a filename usable as one path element below the key directory. %, / and \ are written as %XX,
+like control characters, and so is a leading dot, so a name can never be ".", ".." or a path.
+Filenames stored by StoreDir contain slashes.
+*/
+func escapeName(fileName string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(fileName); i++ {
+		c := fileName[i]
+		if c == '%' || c == '/' || c == '\\' || c < 0x20 || c == 0x7f || (i == 0 && c == '.') {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		} else {
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
+
+/*
+unescapeName returns the filename of a path element made by escapeName.
+*/
+func unescapeName(element string) string {
+	var fileName strings.Builder
+	for i := 0; i < len(element); i++ {
+		if element[i] == '%' && i+2 < len(element) {
+			if c, err := strconv.ParseUint(element[i+1:i+3], 16, 8); err == nil {
+				fileName.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		fileName.WriteByte(element[i])
+	}
+	return fileName.String()
+}
+
+/*
+checkName returns an error if fileName cannot be stored: it must be non-empty UTF-8 without control characters,
+must not end in @<digits>, which GetFile takes as a version, and at most MaxNameLength bytes once encoded.
+*/
+func checkName(fileName string) error {
+	if fileName == "" {
+		return fmt.Errorf("the filename is empty")
+	}
+	if !utf8.ValidString(fileName) {
+		return fmt.Errorf("the filename %.70q is not valid UTF-8", fileName)
+	}
+	for _, r := range fileName {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("the filename %.70q contains control characters", fileName)
+		}
+	}
+	if hasVersionSuffix(fileName) {
+		return fmt.Errorf("the filename %.70q ends in @<number>, which is read as a version", fileName)
+	}
+	if length := len(escapeName(fileName)); length > MaxNameLength {
+		return fmt.Errorf("the filename %.40q... is too long: %d bytes, at most %d (%%, / and \\ count as three)", fileName, length, MaxNameLength)
+	}
+	return nil
+}
+
+/*
+checkFileName returns an error if a file cannot be stored under fileName. Besides checkName, the name must not
+contain the marker of fragments or start with the prefix of values: the nodes keep those names apart from files,
+so such a file would not be copied, listed or found by anti-entropy, or would collide with a value stored with Put.
+*/
+func checkFileName(fileName string) error {
+	if err := checkName(fileName); err != nil {
+		return err
+	}
+	if strings.Contains(fileName, fragmentMarker) {
+		return fmt.Errorf("the filename %.70q contains %s, which marks the fragments of erasure coded files", fileName, fragmentMarker)
+	}
+	if isValueName(fileName) {
+		return fmt.Errorf("the filename %.70q starts with %s, which marks values stored with Put", fileName, valuePrefix)
+	}
+	return nil
+}
+
+/*
+hasVersionSuffix returns true if fileName ends in @ and digits, like the name@version of GetFile.
+*/
+func hasVersionSuffix(fileName string) bool {
+	at := strings.LastIndex(fileName, "@")
+	if at < 0 || at == len(fileName)-1 {
+		return false
+	}
+	return strings.Trim(fileName[at+1:], "0123456789") == ""
+}
+
+/*
+checkKey returns an error if the key is not an identifier on the ring, [0, 2^M).
+*/
+func (n *Node) checkKey(Key *big.Int) error {
+	if Key.Sign() < 0 || Key.Cmp(&n.M2) >= 0 {
+		return fmt.Errorf("key %s is outside the ring, keys are 0 to %s", Key.String(), new(big.Int).Sub(&n.M2, big.NewInt(1)).String())
+	}
+	return nil
+}
+
+/*
+checkHash returns an error if hash is not a SHA-256 in hex. Content is stored under its hash.
+*/
+func checkHash(hash string) error {
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return fmt.Errorf("%.70q is not a SHA-256 content hash", hash)
+	}
+	return nil
+}
+
+/*
+checkFile returns an error if a request for fileName under Key should be refused.
+*/
+func (n *Node) checkFile(Key *big.Int, fileName string) error {
+	if err := n.checkKey(Key); err != nil {
+		return err
+	}
+	return checkName(fileName)
+}
+
+/*
+checkChunk returns an error if the name, key or hash of a chunk sent or asked for is not valid.
+*/
+func (n *Node) checkChunk(chunk Chunk) error {
+	if chunk.Offset < 0 || chunk.Version < 0 {
+		return fmt.Errorf("chunk for %.70q has a negative offset or version", chunk.FileName)
+	}
+	if chunk.FileName == "" && chunk.Hash != "" {
+		return checkHash(chunk.Hash) //Content asked for by its hash
+	}
+	if err := n.checkFile(&chunk.ID, chunk.FileName); err != nil {
+		return err
+	}
+	if chunk.Hash != "" {
+		return checkHash(chunk.Hash)
+	}
+	return nil
+}
+
+/*
+checkChunkData returns an error if the data, offset and size of a chunk that is sent do not fit together.
+*/
+func checkChunkData(chunk Chunk) error {
+	if len(chunk.Data) > ChunkSize {
+		return fmt.Errorf("chunk of %d bytes, at most %d bytes are sent in one chunk", len(chunk.Data), ChunkSize)
+	}
+	if chunk.Size < 0 || chunk.Offset+int64(len(chunk.Data)) > chunk.Size {
+		return fmt.Errorf("chunk for %.70q has offset %d and %d bytes, which does not fit a file of %d bytes", chunk.FileName, chunk.Offset, len(chunk.Data), chunk.Size)
+	}
+	return nil
+}
+
+/*
+checkRequest is called by CallHandler before a request is handled. Returns an error, which is sent back as the
+reply, if the filenames, keys, hashes or sizes in the request cannot be stored or looked up safely.
+*/
+func (n *Node) checkRequest(sendArgs *SendArgs) error {
+	switch {
+	case sendArgs.StoreFileRequest:
+		if len(sendArgs.File.Content) > MaxValueSize {
+			return fmt.Errorf("the file is %d bytes, at most %d bytes are sent in one call", len(sendArgs.File.Content), MaxValueSize)
+		}
+		return n.checkFile(&sendArgs.File.ID, sendArgs.File.FileName)
+	case sendArgs.PutValueRequest, sendArgs.GetValueRequest:
+		if len(sendArgs.File.Content) > MaxValueSize {
+			return fmt.Errorf("the value is %d bytes, at most %d bytes can be stored with Put", len(sendArgs.File.Content), MaxValueSize)
+		}
+		return n.checkFile(&sendArgs.File.ID, valueName(sendArgs.File.FileName))
+	case sendArgs.ListVersionsRequest, sendArgs.DeleteFileRequest, sendArgs.DeleteReplicaRequest, sendArgs.CacheFileRequest, sendArgs.InvalidateCacheRequest:
+		return n.checkFile(&sendArgs.File.ID, sendArgs.File.FileName)
+	case sendArgs.ReconcileRequest:
+		for _, version := range sendArgs.Versions {
+			if err := checkHash(version.Hash); err != nil {
+				return err
+			}
+		}
+		return n.checkFile(&sendArgs.File.ID, sendArgs.File.FileName)
+	case sendArgs.StoreChunkRequest, sendArgs.ChunkOffsetRequest:
+		if err := checkChunkData(sendArgs.Chunk); err != nil {
+			return err
+		}
+		return n.checkChunk(sendArgs.Chunk)
+	case sendArgs.GetChunkRequest:
+		return n.checkChunk(sendArgs.Chunk)
+	case sendArgs.RecursiveLookupRequest:
+		if sendArgs.Steps < 1 || sendArgs.Steps > MaxSteps {
+			return fmt.Errorf("a lookup takes 1 to %d steps, not %d", MaxSteps, sendArgs.Steps)
+		}
+		return n.checkKey(&sendArgs.SendArg)
+	case sendArgs.LinkBlobRequest:
+		if err := checkHash(sendArgs.Chunk.Hash); err != nil {
+			return err
+		}
+		return n.checkChunk(sendArgs.Chunk)
+	}
+	return nil
+}
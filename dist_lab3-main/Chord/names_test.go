@@ -0,0 +1,123 @@
+package Chord
+
+import (
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		escaped string
+	}{
+		{"report.txt", "report.txt"},
+		{".", "%2E"},
+		{"..", "%2E."},
+		{".hidden", "%2Ehidden"},
+		{"a.b.", "a.b."},
+		{"../../etc/passwd", "%2E.%2F..%2Fetc%2Fpasswd"},
+		{"/etc/x", "%2Fetc%2Fx"},
+		{`dir\file`, "dir%5Cfile"},
+		{"100%", "100%25"},
+		{"%2F", "%252F"},
+		{"tab\there", "tab%09here"},
+		{"del\x7f", "del%7F"},
+		{"räksmörgås", "räksmörgås"},
+		{"user@host", "user@host"},
+	}
+	for _, test := range tests {
+		escaped := escapeName(test.name)
+		if escaped != test.escaped {
+			t.Errorf("escapeName(%q) = %q, want %q", test.name, escaped, test.escaped)
+		}
+		if strings.ContainsAny(escaped, `/\`) || escaped == "." || escaped == ".." || filepath.Base(escaped) != escaped {
+			t.Errorf("escapeName(%q) = %q is not one path element", test.name, escaped)
+		}
+		if back := unescapeName(escaped); back != test.name {
+			t.Errorf("unescapeName(%q) = %q, want %q", escaped, back, test.name)
+		}
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	tests := []struct {
+		name    string
+		refused string //Part of the error, empty if the name can be stored
+	}{
+		{"report.txt", ""},
+		{"../../x", ""}, //Stored as one escaped path element
+		{"dir/sub/file", ""},
+		{"räksmörgås", ""},
+		{strings.Repeat("a", MaxNameLength), ""},
+		{"", "empty"},
+		{"bad\xffutf8", "UTF-8"},
+		{"line\nbreak", "control"},
+		{"nul\x00", "control"},
+		{"del\x7f", "control"},
+		{strings.Repeat("a", MaxNameLength+1), "too long"},
+		{strings.Repeat("/", MaxNameLength/3+1), "too long"}, //Every / takes three bytes escaped
+		{"report@2", "version"},
+	}
+	for _, test := range tests {
+		err := checkName(test.name)
+		switch {
+		case test.refused == "" && err != nil:
+			t.Errorf("checkName(%.30q): %s", test.name, err)
+		case test.refused != "" && (err == nil || !strings.Contains(err.Error(), test.refused)):
+			t.Errorf("checkName(%.30q) = %v, want an error about %q", test.name, err, test.refused)
+		}
+	}
+}
+
+func TestCheckFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		refused string //Part of the error, empty if a file can be stored under the name
+	}{
+		{"report.txt", ""},
+		{"fragment.txt", ""},
+		{"#values", ""},
+		{"a#value:b", ""},
+		{"report@2", "version"}, //Everything checkName refuses
+		{"notes#fragment.txt", "fragments"},
+		{"big.iso#fragment3", "fragments"},
+		{"#value:config", "values"},
+	}
+	for _, test := range tests {
+		err := checkFileName(test.name)
+		switch {
+		case test.refused == "" && err != nil:
+			t.Errorf("checkFileName(%q): %s", test.name, err)
+		case test.refused != "" && (err == nil || !strings.Contains(err.Error(), test.refused)):
+			t.Errorf("checkFileName(%q) = %v, want an error about %q", test.name, err, test.refused)
+		}
+	}
+	//The names the nodes give fragments and values are still valid names on the wire
+	for _, name := range []string{"big.iso#fragment3", valueName("config")} {
+		if err := checkName(name); err != nil {
+			t.Errorf("checkName(%q): %s", name, err)
+		}
+	}
+}
+
+func TestCheckKeyAndHash(t *testing.T) {
+	n := &Node{M2: *big.NewInt(1 << 16)}
+	for key, valid := range map[int64]bool{0: true, 1<<16 - 1: true, 1 << 16: false, -1: false} {
+		if err := n.checkKey(big.NewInt(key)); (err == nil) != valid {
+			t.Errorf("checkKey(%d) = %v", key, err)
+		}
+	}
+	for hash, valid := range map[string]bool{
+		strings.Repeat("0a", 32):        true,
+		strings.Repeat("0A", 32):        false,
+		strings.Repeat("0a", 31):        false,
+		"../" + strings.Repeat("a", 61): false,
+		strings.Repeat("g", 64):         false,
+	} {
+		if err := checkHash(hash); (err == nil) != valid {
+			t.Errorf("checkHash(%q) = %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package Chord
+
+import (
+	"math/big"
+)
+
+/*
+A node's ID is the SHA-1 of its address modulo 2^m, or the 40 hex characters given with -i modulo 2^m, so nodes can be
+placed on the ring on purpose. The ID of another node can therefore not be computed from its address.
+
+The successor list, finger table and predecessor hold addresses, and every node keeps the IDs of the addresses it
+knows in peerIDs. Every reply carries the ID of the node that answers (NodeID) and the IDs of the nodes it names
+(PeerIDs), and call learns them. notify sends the ID of the node that notifies. An ID that is still not known is
+asked for with a ping. peerIDs is guarded by idLock, which is taken last and never held across an RPC.
+*/
+
+// A node named in a reply, with its ID.
+type Peer struct {
+	Address string
+	ID      big.Int
+}
+
+/*
+userIDValue returns the value of an -i identifier, 40 hex characters checked by handelFlags.
+*/
+func userIDValue(userID string) big.Int {
+	value, _ := new(big.Int).SetString(userID, 16)
+	return *value
+}
+
+/*
+learnID records that the node on address has ID id.
+*/
+func (n *Node) learnID(address string, id big.Int) {
+	if address == "" {
+		return
+	}
+	n.idLock.Lock()
+	defer n.idLock.Unlock()
+	n.peerIDs[address] = id
+}
+
+/*
+learnIDs records the IDs in a reply from the node on address.
+*/
+func (n *Node) learnIDs(address string, reply *ReceiveArgs) {
+	if reply.NodeID != nil {
+		n.learnID(address, *reply.NodeID)
+	}
+	for _, peer := range reply.PeerIDs {
+		n.learnID(peer.Address, peer.ID)
+	}
+}
+
+/*
+knownID returns the ID of the node on address, nil if it is not known. Makes no call, so it can be used with
+ringLock or fileLock held.
+*/
+func (n *Node) knownID(address string) *big.Int {
+	n.idLock.Lock()
+	defer n.idLock.Unlock()
+	id, ok := n.peerIDs[address]
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
+/*
+knownIDs returns the known IDs of addresses, to send with a reply that names them.
+*/
+func (n *Node) knownIDs(addresses ...string) []Peer {
+	peers := make([]Peer, 0, len(addresses))
+	for _, address := range addresses {
+		if id := n.knownID(address); id != nil {
+			peers = append(peers, Peer{Address: address, ID: *id})
+		}
+	}
+	return peers
+}
+
+/*
+peerID returns the ID of the node on address, and pings it to learn the ID if it is not known.
+Returns nil if address is empty or the node does not answer.
+*/
+func (n *Node) peerID(address string) *big.Int {
+	if address == "" {
+		return nil
+	}
+	if id := n.knownID(address); id != nil {
+		return id
+	}
+	SenderArgs := SendArgs{CheckSucORPredFail: true}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address) {
+		return nil
+	}
+	return n.knownID(address)
+}
+
+/*
+idText returns id for PrintDetails, "?" if it is not known.
+*/
+func idText(id *big.Int) string {
+	if id == nil {
+		return "?"
+	}
+	return id.String()
+}
@@ -0,0 +1,242 @@
+package Chord
+
+import (
+	"fmt"
+	"math/big"
+)
+
+/*
+replicaDirectory is the directory on disk where the node keeps the copies of files
+that another node is the primary owner of.
+*/
+func (n *Node) replicaDirectory() string {
+	return "replica"
+}
+
+/*
+replicaTargets returns the first k distinct nodes in the successor list (flag -k).
+The node itself is never a target, which can happen when the ring is smaller than the successor list.
+*/
+func (n *Node) replicaTargets(successors []string) []string {
+	targets := make([]string, 0, n.Flags.K)
+	for _, successor := range successors {
+		if len(targets) >= n.Flags.K {
+			break
+		}
+		if successor == "" || successor == n.Address || contains(targets, successor) {
+			continue
+		}
+		targets = append(targets, successor)
+	}
+	return targets
+}
+
+/*
+contains returns true if value is one of the strings in list
+*/
+func contains(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+replicate sends a copy of the given files (key -> filenames) to each of the first k successors.
+*/
+func (n *Node) replicate(files map[string][]string) {
+	if len(files) == 0 {
+		return
+	}
+	for _, target := range n.replicaTargets(n.successorList()) {
+		n.sendReplicas(target, files)
+	}
+}
+
+/*
+sendReplicas streams the given files from the bucket to the node on address.
+The receiver stores them as copies with the current node as primary owner. If the receiver no longer answers
+after a file failed, the rest is not sent: every file would wait for its retries, and stabilize waits for this.
+*/
+func (n *Node) sendReplicas(address string, files map[string][]string) bool {
+	allSent := true
+	for key, fileNames := range files {
+		for _, fileName := range fileNames {
+			if isFragmentName(fileName) {
+				continue //Fragments of erasure coded files are not copied, the parity fragments protect them
+			}
+			if !n.sendVersions(address, n.bucketDirectory(), key, fileName, true) {
+				fmt.Printf("Error during sendReplicas of %s to %s\n", fileName, address)
+				allSent = false
+
+				SenderArgs := SendArgs{CheckSucORPredFail: true}
+				ReceiveArgs := ReceiveArgs{}
+				if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address) {
+					fmt.Printf("%s does not answer, the other copies are not sent to it\n", address)
+					return false
+				}
+			}
+		}
+	}
+	return allSent
+}
+
+/*
+syncReplicas is called by stabilize after the successor list has been updated. Every node that
+has become one of the first k successors since the last round receives copies of all files in the bucket.
+Every node that is no longer one of them is told to drop its copies, deletes do not reach it anymore, and its
+stale copies would come back if it took over the keys later. That is only done when k targets are known,
+a successor list that is being filled after a join or a failure does not make nodes drop their copies.
+*/
+func (n *Node) syncReplicas(oldSuccessors []string) {
+	oldTargets := n.replicaTargets(oldSuccessors)
+	targets := n.replicaTargets(n.successorList())
+
+	if len(targets) == n.Flags.K {
+		for _, target := range oldTargets {
+			if !contains(targets, target) {
+				n.dropReplicas(target)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		if contains(oldTargets, target) {
+			continue
+		}
+		bucket := n.bucketFiles()
+		if len(bucket) == 0 {
+			return
+		}
+		if Debugging {
+			fmt.Printf("New successor %s, sending copies of %d keys\n", target, len(bucket))
+		}
+		n.sendReplicas(target, bucket)
+	}
+}
+
+/*
+dropReplicas tells the node on address to drop the copies it holds of the files of this node.
+*/
+func (n *Node) dropReplicas(address string) {
+	SenderArgs := SendArgs{DropReplicasRequest: true, SendArgString: n.Address}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.callTimeout("Node.CallHandler", &SenderArgs, &ReceiveArgs, address, CallTimeout) {
+		fmt.Printf("Could not tell %s to drop its copies, it is no longer one of the first %d successors\n", address, n.Flags.K)
+	} else if Debugging {
+		fmt.Println(ReceiveArgs.ReplyArgs)
+	}
+}
+
+/*
+dropReplicasOf deletes the copies of every key whose primary owner is the node on owner. Returns the number of keys.
+The caller holds n.fileLock.
+*/
+func (n *Node) dropReplicasOf(owner string) int {
+	dropped := 0
+	for key, origin := range n.ReplicaOwner {
+		if origin == owner {
+			n.removeReplica(key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+/*
+addReplica registers a copy that has been saved in the replica directory, sent by its primary owner at address origin.
+Keys the node is the primary owner of itself are skipped. The caller holds n.fileLock.
+*/
+func (n *Node) addReplica(key string, fileName string, origin string) {
+	if _, primary := n.Bucket[key]; primary {
+		n.removeVersions(n.replicaDirectory(), key, fileName)
+		return
+	}
+
+	if !contains(n.Replicas[key], fileName) {
+		n.Replicas[key] = append(n.Replicas[key], fileName)
+	}
+	n.ReplicaOwner[key] = origin
+}
+
+/*
+removeReplica deletes the copies stored under key, both from the maps and from disk. The caller holds n.fileLock.
+*/
+func (n *Node) removeReplica(key string) {
+	if _, ok := n.Replicas[key]; !ok {
+		return
+	}
+	n.removeKeyDirectory(n.replicaDirectory(), key, n.Replicas[key])
+	delete(n.Replicas, key)
+	delete(n.ReplicaOwner, key)
+}
+
+/*
+promoteReplicas is called when the predecessor on address dead has failed, with from nil, and when a new
+predecessor with ID from follows a failed one, with dead empty. The current node is now responsible for the keys of
+the failed node, and for every key in (from, n] if further predecessors failed with it. The copies it holds of those
+keys, whichever node they came from, are moved into the bucket and copied on to the successors, which keeps k copies
+of every file in the ring.
+*/
+func (n *Node) promoteReplicas(dead string, from *big.Int) {
+	promoted := make(map[string][]string)
+	n.fileLock.Lock()
+
+	for key, owner := range n.ReplicaOwner {
+		KeyBigInt, _ := new(big.Int).SetString(key, 10)
+		ours := from != nil && KeyBigInt != nil && between(from, KeyBigInt, &n.Id, true)
+		if owner != dead && !ours {
+			continue
+		}
+		for _, fileName := range n.Replicas[key] {
+			replicaPath := versionsPath(n.replicaDirectory(), key, fileName)
+			err := n.storage.Rename(replicaPath, versionsPath(n.bucketDirectory(), key, fileName))
+			if CheckError(err, "Rename in promoteReplicas") {
+				fmt.Printf("No such file on disk in promoteReplicas\n")
+				continue
+			}
+			promoted[key] = append(promoted[key], fileName)
+		}
+		n.removeReplica(key)
+	}
+
+	for key, fileNames := range promoted {
+		for _, fileName := range fileNames {
+			if !contains(n.Bucket[key], fileName) {
+				n.Bucket[key] = append(n.Bucket[key], fileName)
+			}
+		}
+	}
+	n.fileLock.Unlock()
+
+	if len(promoted) == 0 {
+		return
+	}
+	if dead != "" {
+		fmt.Printf("Taking over %d keys from failed node %s\n", len(promoted), dead)
+	} else {
+		fmt.Printf("Taking over %d keys after (%s, %s], their owners failed\n", len(promoted), from.String(), n.Id.String())
+	}
+	n.replicate(promoted)
+}
+
+/*
+deleteReplicaFile deletes the copy of a single file stored under key, after the primary owner deleted it.
+Returns false if there was no such copy. The caller holds n.fileLock.
+*/
+func (n *Node) deleteReplicaFile(key string, fileName string) bool {
+	remaining, found := removeName(n.Replicas[key], fileName)
+	if !found {
+		return false
+	}
+
+	if len(remaining) == 0 {
+		n.removeReplica(key)
+	} else {
+		n.removeVersions(n.replicaDirectory(), key, fileName)
+		n.Replicas[key] = remaining
+	}
+	return true
+}
@@ -0,0 +1,763 @@
+package Chord
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+The tests run several nodes in one process, each with its own port, RPC server and memory storage, and short
+intervals for the periodical functions. Run them with go test -race ./... to check the locking in state.go.
+*/
+
+// How long a test waits for the ring to settle or a file to arrive before it fails.
+const settleTimeout = 15 * time.Second
+
+/*
+freePort returns a port on localhost that nothing listens on.
+*/
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no free port: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+/*
+testFlags returns the flags of a test node that creates a ring (join == nil) or joins the ring of join.
+*/
+func testFlags(t *testing.T, join *Node) Flags {
+	flags := Flags{IP: "127.0.0.1", Port: freePort(t), Ts: 100, Tff: 100, Tcp: 200, R: 4, M: 16, K: 2, Versions: 3,
+		Tsc: 1000, Storage: "memory", Tex: 500, Tae: 500, DataDir: t.TempDir(), Cache: 1}
+	if join != nil {
+		flags.JA, flags.JP = join.Flags.IP, join.Flags.Port
+	}
+	return flags
+}
+
+/*
+startTestNode starts a node that creates a ring (join == nil) or joins the ring of join. The node is stopped when the test ends.
+*/
+func startTestNode(t *testing.T, join *Node) *Node {
+	t.Helper()
+	return startNodeWith(t, testFlags(t, join))
+}
+
+/*
+startNodeWith starts a node with flags, it is stopped when the test ends.
+*/
+func startNodeWith(t *testing.T, flags Flags) *Node {
+	t.Helper()
+	n, err := startNode(flags, flags.JA == "")
+	if err != nil {
+		t.Fatalf("startNode: %s", err)
+	}
+	t.Cleanup(func() { stopTestNode(n) })
+	return n
+}
+
+/*
+stopTestNode stops the node as if it crashed: it stops answering and its periodical functions end.
+*/
+func stopTestNode(n *Node) {
+	select {
+	case <-n.stopChan:
+	default:
+		close(n.stopChan)
+	}
+}
+
+/*
+ringStable returns true if the successors and predecessors of the nodes form one ring in the order of their IDs.
+*/
+func ringStable(nodes []*Node) bool {
+	sorted := append([]*Node(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id.Cmp(&sorted[j].Id) < 0 })
+	for i, n := range sorted {
+		next := sorted[(i+1)%len(sorted)]
+		previous := sorted[(i+len(sorted)-1)%len(sorted)]
+		if n.successor() != next.Address || n.predecessor() != previous.Address {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+waitFor polls condition until it is true, and fails the test with what if that takes longer than settleTimeout.
+*/
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+/*
+startRing starts count nodes, joined one after the other, and waits until they form a stable ring.
+*/
+func startRing(t *testing.T, count int) []*Node {
+	t.Helper()
+	nodes := []*Node{startTestNode(t, nil)}
+	for len(nodes) < count {
+		nodes = append(nodes, startTestNode(t, nodes[0]))
+	}
+	waitFor(t, "a stable ring", func() bool { return ringStable(nodes) })
+	return nodes
+}
+
+/*
+hasValue returns true if key has value when it is read through n.
+*/
+func hasValue(n *Node, key string, value []byte) bool {
+	got, err := n.Get(key)
+	return err == nil && bytes.Equal(got, value)
+}
+
+func TestRingConcurrentPutGet(t *testing.T) {
+	nodes := startRing(t, 3)
+
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(n *Node, key string) {
+				defer wg.Done()
+				for version := 1; version <= 3; version++ {
+					if err := n.Put(key, []byte(fmt.Sprintf("%s v%d", key, version))); err != nil {
+						t.Errorf("Put %s: %s", key, err)
+						return
+					}
+					n.Get(key) //Not checked, during the join the value can be read from the new owner before it has the latest version
+				}
+			}(n, fmt.Sprintf("key-%d-%d", i, j))
+		}
+	}
+	//A node joins while the values are stored, the keys it becomes responsible for are handed over to it
+	joined := startTestNode(t, nodes[0])
+	wg.Wait()
+
+	nodes = append(nodes, joined)
+	waitFor(t, "a stable ring after the join", func() bool { return ringStable(nodes) })
+	for i := range nodes[:3] {
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(n *Node, key string) {
+				defer wg.Done()
+				value := []byte(key + " final")
+				if err := n.Put(key, value); err != nil {
+					t.Errorf("Put %s: %s", key, err)
+					return
+				}
+				for _, reader := range nodes {
+					if !hasValue(reader, key, value) {
+						t.Errorf("Get %s through %s did not return the value stored last", key, reader.Address)
+					}
+				}
+			}(nodes[(i+j)%len(nodes)], fmt.Sprintf("key-%d-%d", i, j))
+		}
+	}
+	wg.Wait()
+}
+
+func TestRingConcurrentFiles(t *testing.T) {
+	nodes := startRing(t, 3)
+	source, destination := t.TempDir(), t.TempDir()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		n := nodes[i%len(nodes)]
+		fileName := fmt.Sprintf("file%d.txt", i)
+		content := bytes.Repeat([]byte(fileName), 1000+i)
+		filePath := filepath.Join(source, fileName)
+		if err := os.WriteFile(filePath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(i int, n *Node, fileName string, filePath string, content []byte) {
+			defer wg.Done()
+			if !n.storeAs(filePath, fileName, 0) {
+				t.Errorf("StoreFile %s failed", fileName)
+				return
+			}
+			reader := nodes[(i+1)%len(nodes)]
+			destPath := filepath.Join(destination, reader.Address+"-"+fileName)
+			if !reader.GetFile(fileName, destPath) {
+				t.Errorf("GetFile %s through %s failed", fileName, reader.Address)
+				return
+			}
+			got, err := os.ReadFile(destPath)
+			if err != nil || !bytes.Equal(got, content) {
+				t.Errorf("GetFile %s through %s returned other content", fileName, reader.Address)
+			}
+			if i%2 == 0 && !nodes[(i+2)%len(nodes)].DeleteFile(fileName) {
+				t.Errorf("DeleteFile %s failed", fileName)
+			}
+		}(i, n, fileName, filePath, content)
+	}
+	wg.Wait()
+
+	listed := func() map[string]bool {
+		names := make(map[string]bool)
+		for _, n := range nodes {
+			for _, file := range n.listFiles() {
+				names[file.FileName] = true
+			}
+		}
+		return names
+	}
+	waitFor(t, "the kept files, and only those, in the buckets", func() bool {
+		names := listed()
+		for i := 0; i < 12; i++ {
+			if names[fmt.Sprintf("file%d.txt", i)] != (i%2 == 1) {
+				return false
+			}
+		}
+		return len(names) == 6
+	})
+}
+
+func TestRingNodeFailure(t *testing.T) {
+	nodes := startRing(t, 4)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("value-%d", i)
+		values[key] = []byte(key)
+		if err := nodes[i%len(nodes)].Put(key, values[key]); err != nil {
+			t.Fatalf("Put %s: %s", key, err)
+		}
+	}
+	//Every file has been copied to the first k successors of its owner once the owners have the ring in view
+	time.Sleep(500 * time.Millisecond)
+
+	stopTestNode(nodes[1])
+	alive := []*Node{nodes[0], nodes[2], nodes[3]}
+	waitFor(t, "a stable ring without the failed node", func() bool { return ringStable(alive) })
+
+	for key, value := range values {
+		for _, n := range alive {
+			waitFor(t, key+" through "+n.Address, func() bool { return hasValue(n, key, value) })
+		}
+	}
+}
+
+func TestRingLookupModes(t *testing.T) {
+	nodes := startRing(t, 4)
+	waitFor(t, "the finger tables", func() bool {
+		for _, n := range nodes {
+			for _, finger := range n.fingers() {
+				if finger == "" {
+					return false
+				}
+			}
+		}
+		return true
+	})
+
+	byAddress := make(map[string]*Node)
+	for _, n := range nodes {
+		byAddress[n.Address] = n
+	}
+	for i := 0; i < 40; i++ {
+		id := hashModulo(Hash(fmt.Sprintf("lookup-%d", i)), nodes[0].M2)
+		n := nodes[i%len(nodes)]
+		iterative, iterativeHops, err := n.lookup(*id, n.Address, MaxSteps, LookupIterative)
+		if err != nil {
+			t.Fatalf("iterative lookup of %s: %s", id, err)
+		}
+		recursive, recursiveHops, err := n.lookup(*id, n.Address, MaxSteps, LookupRecursive)
+		if err != nil {
+			t.Fatalf("recursive lookup of %s: %s", id, err)
+		}
+		if iterative != recursive || len(iterativeHops) != len(recursiveHops) {
+			t.Errorf("lookup of %s: iterative found %s in %d hops, recursive %s in %d hops", id, iterative, len(iterativeHops), recursive, len(recursiveHops))
+			continue
+		}
+
+		//Both paths go through the same nodes, each hop answers with the next one and the last with the owner.
+		//Where a hop found the answer can differ, fix_fingers may have replaced a finger by then.
+		for j := range iterativeHops {
+			a, b := iterativeHops[j], recursiveHops[j]
+			if a.Address != b.Address || a.ID.Cmp(&b.ID) != 0 || a.Answer != b.Answer {
+				t.Errorf("lookup of %s, hop %d: iterative %+v, recursive %+v", id, j+1, a, b)
+			}
+			if byAddress[a.Address] == nil || a.ID.Cmp(&byAddress[a.Address].Id) != 0 || a.Via == "" || a.RTT <= 0 || b.RTT <= 0 {
+				t.Errorf("lookup of %s, hop %d has no ID, source or round trip: %+v %+v", id, j+1, a, b)
+			}
+			next := iterative
+			if j+1 < len(iterativeHops) {
+				next = iterativeHops[j+1].Address
+			}
+			if a.Answer != next {
+				t.Errorf("lookup of %s, hop %d answered %s but the next hop is %s", id, j+1, a.Answer, next)
+			}
+		}
+	}
+
+	//Both modes give up after the same number of hops with the same error
+	for _, n := range nodes {
+		successorID := n.knownID(n.successor())
+		beyond := new(big.Int).Add(successorID, big.NewInt(1)) //The successor is not responsible, one more hop is needed
+		beyond.Mod(beyond, &n.M2)
+		_, _, iterativeErr := n.lookup(*beyond, n.Address, 1, LookupIterative)
+		_, _, recursiveErr := n.lookup(*beyond, n.Address, 1, LookupRecursive)
+		if iterativeErr == nil || recursiveErr == nil || iterativeErr.Error() != recursiveErr.Error() {
+			t.Errorf("lookup of %s in one hop: iterative error %v, recursive error %v", beyond, iterativeErr, recursiveErr)
+		}
+	}
+}
+
+/*
+ringOwner returns the node responsible for id: the first node whose ID is id or follows it on the ring.
+*/
+func ringOwner(nodes []*Node, id *big.Int) *Node {
+	sorted := append([]*Node(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id.Cmp(&sorted[j].Id) < 0 })
+	for _, n := range sorted {
+		if n.Id.Cmp(id) >= 0 {
+			return n
+		}
+	}
+	return sorted[0]
+}
+
+func TestRingFingerSelection(t *testing.T) {
+	//Finger 16 of the node at 1000 covers [33768, 1000), which holds the nodes at 40000 and 50000
+	nodes := startPlacedRing(t, []int64{1000, 10000, 40000, 50000})
+
+	//Every finger is a node in its interval, or the successor of the interval's start if no node is in it
+	validFingers := func() bool {
+		for _, n := range nodes {
+			for i, finger := range n.fingers() {
+				next := i + 1
+				if finger == "" {
+					return false
+				}
+				exact := ringOwner(nodes, n.jump(n.Id, next))
+				if id := n.peerID(finger); finger != exact.Address && (id == nil || !n.inFingerInterval(id, next)) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	waitFor(t, "fingers in their intervals", validFingers)
+
+	for i := 0; i < 40; i++ {
+		id := hashModulo(Hash(fmt.Sprintf("finger-%d", i)), nodes[0].M2)
+		owner := ringOwner(nodes, id).Address
+		for _, n := range nodes {
+			if found, address := n.find(*id, n.Address, MaxSteps); !found || address != owner {
+				t.Errorf("lookup of %s through %s found %s, the owner is %s", id, n.Address, address, owner)
+			}
+		}
+	}
+
+	//Where an interval holds several nodes the fastest is chosen, not the exact successor of the start
+	n, next := nodes[0], nodes[0].M
+	exact, fast := nodes[2], nodes[3]
+	if ringOwner(nodes, n.jump(n.Id, next)) != exact || !n.inFingerInterval(&exact.Id, next) || !n.inFingerInterval(&fast.Id, next) {
+		t.Fatalf("the nodes at %s and %s are not both in finger %d of %s", exact.Id.String(), fast.Id.String(), next, n.Id.String())
+	}
+	n.latency.forget(exact.Address)
+	n.latency.record(exact.Address, time.Second)
+	n.latency.forget(fast.Address) //Measured again by chooseFinger
+	if chosen := n.chooseFinger(next, exact.Address); chosen != fast.Address {
+		t.Errorf("finger %d of %s: chose %s, %s is in the interval and faster", next, n.Address, chosen, fast.Address)
+	}
+}
+
+/*
+userID returns an -i identifier, 40 hex characters, whose position on a ring of 2^16 is position.
+*/
+func userID(position int64) string {
+	return fmt.Sprintf("%036x%04x", 0xabc, position)
+}
+
+/*
+startPlacedRing starts a node at every position with -i, joined one after the other, and waits until they form a stable ring.
+*/
+func startPlacedRing(t *testing.T, positions []int64) []*Node {
+	t.Helper()
+	var nodes []*Node
+	for _, position := range positions {
+		var join *Node
+		if len(nodes) > 0 {
+			join = nodes[0]
+		}
+		flags := testFlags(t, join)
+		flags.UserID = userID(position)
+		n := startNodeWith(t, flags)
+		if n.Id.Cmp(big.NewInt(position)) != 0 {
+			t.Fatalf("node started with -i %s has ID %s, not %d", flags.UserID, n.Id.String(), position)
+		}
+		nodes = append(nodes, n)
+	}
+	waitFor(t, "a stable ring of the placed nodes", func() bool { return ringStable(nodes) })
+	return nodes
+}
+
+func TestRingPlacedNodes(t *testing.T) {
+	positions := []int64{1000, 20000, 40000, 60000}
+	nodes := startPlacedRing(t, positions)
+
+	//Every node knows the real IDs of its neighbours, not the hashes of their addresses
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if id := n.peerID(other.Address); id == nil || id.Cmp(&other.Id) != 0 {
+				t.Errorf("%s knows %s as ID %v, its ID is %s", n.Address, other.Address, id, other.Id.String())
+			}
+		}
+	}
+
+	for _, key := range []int64{0, 999, 1000, 1001, 20000, 39999, 59000, 60001, 65535} {
+		id := big.NewInt(key)
+		owner := ringOwner(nodes, id).Address
+		for _, n := range nodes {
+			if found, address := n.find(*id, n.Address, MaxSteps); !found || address != owner {
+				t.Errorf("lookup of %d through %s found %s, the owner is %s", key, n.Address, address, owner)
+			}
+		}
+	}
+
+	value := []byte("placed")
+	if err := nodes[1].Put("placed-key", value); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	for _, n := range nodes {
+		if !hasValue(n, "placed-key", value) {
+			t.Errorf("Get placed-key through %s did not return the value", n.Address)
+		}
+	}
+
+	//A node cannot join at the position of another node
+	flags := testFlags(t, nodes[0])
+	flags.UserID = userID(positions[2])
+	if n, err := startNode(flags, false); err == nil {
+		stopTestNode(n)
+		t.Errorf("a node joined with the ID %d of %s", positions[2], nodes[2].Address)
+	}
+}
+
+func TestRingAdjacentFailures(t *testing.T) {
+	nodes := startRing(t, 5)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Id.Cmp(&nodes[j].Id) < 0 })
+
+	values := make(map[string][]byte)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("adjacent-%d", i)
+		values[key] = []byte(key)
+		if err := nodes[i%len(nodes)].Put(key, values[key]); err != nil {
+			t.Fatalf("Put %s: %s", key, err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	//A node and its predecessor fail together, the keys of both are taken over by the next node from its copies
+	stopTestNode(nodes[2])
+	stopTestNode(nodes[1])
+	alive := []*Node{nodes[0], nodes[3], nodes[4]}
+	waitFor(t, "a stable ring without the failed nodes", func() bool { return ringStable(alive) })
+
+	for key, value := range values {
+		for _, n := range alive {
+			waitFor(t, key+" through "+n.Address, func() bool { return hasValue(n, key, value) })
+		}
+	}
+}
+
+/*
+hasReplica returns true if n holds a copy of a file stored under key.
+*/
+func hasReplica(n *Node, key string) bool {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+	return len(n.Replicas[key]) > 0
+}
+
+func TestRingDroppedReplicaTarget(t *testing.T) {
+	nodes := startPlacedRing(t, []int64{1000, 20000, 40000, 60000})
+
+	//A value of the node at 20000, copied to the nodes at 40000 and 60000
+	var key string
+	for i := 0; key == ""; i++ {
+		candidate := fmt.Sprintf("dropped-%d", i)
+		if ringOwner(nodes, hashModulo(Hash(candidate), nodes[0].M2)) == nodes[1] {
+			key = candidate
+		}
+	}
+	if err := nodes[0].Put(key, []byte("value")); err != nil {
+		t.Fatalf("Put %s: %s", key, err)
+	}
+	id := hashModulo(Hash(key), nodes[0].M2).String()
+	waitFor(t, "the copy on the node at 60000", func() bool { return hasReplica(nodes[3], id) })
+
+	//A node joins at 30000, the node at 60000 is no longer one of the first k successors of the owner and drops the copy
+	flags := testFlags(t, nodes[0])
+	flags.UserID = userID(30000)
+	joined := startNodeWith(t, flags)
+	waitFor(t, "a stable ring with the node at 30000", func() bool { return ringStable(append(nodes, joined)) })
+	waitFor(t, "the copy on the node at 30000", func() bool { return hasReplica(joined, id) })
+	waitFor(t, "the node at 60000 dropping its copy", func() bool { return !hasReplica(nodes[3], id) })
+	if !hasReplica(nodes[2], id) {
+		t.Errorf("the node at 40000, still a target, has no copy of %s", key)
+	}
+}
+
+/*
+holdsFile returns true if n has fileName under key in its bucket or among its copies, or still keeps one of the blobs.
+*/
+func holdsFile(n *Node, key string, fileName string, hashes []string) bool {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+	if contains(n.Bucket[key], fileName) || contains(n.Replicas[key], fileName) {
+		return true
+	}
+	for _, hash := range hashes {
+		if n.Blobs[hash] > 0 || n.hasBlob(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRingDeleteFile(t *testing.T) {
+	nodes := startRing(t, 3)
+	source, destination := t.TempDir(), t.TempDir()
+	tests := []struct {
+		fileName string
+		stored   int //Versions stored before the delete, 0 if the file was never stored
+	}{
+		{"once.txt", 1},
+		{"versioned.txt", 3},
+		{"dir/nested.txt", 1},
+		{"never.txt", 0},
+	}
+	for _, test := range tests {
+		Key := hashModulo(Hash(test.fileName), nodes[0].M2)
+		key := Key.String()
+		hashes := make([]string, 0, test.stored)
+		for i := 0; i < test.stored; i++ {
+			filePath := filepath.Join(source, "file")
+			if err := os.WriteFile(filePath, []byte(fmt.Sprintf("%s version %d", test.fileName, i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			hash, _ := hashFile(localDisk, filePath)
+			hashes = append(hashes, hash)
+			if !nodes[0].storeAs(filePath, test.fileName, 0) {
+				t.Fatalf("StoreFile %s failed", test.fileName)
+			}
+		}
+		if test.stored > 0 {
+			//With k = 2 every node holds the file, the owner in its bucket and the others as copies
+			for _, n := range nodes {
+				waitFor(t, test.fileName+" on "+n.Address, func() bool { return holdsFile(n, key, test.fileName, nil) })
+			}
+		}
+
+		if deleted := nodes[1].DeleteFile(test.fileName); deleted != (test.stored > 0) {
+			t.Errorf("DeleteFile(%q) = %t, it was stored %d times", test.fileName, deleted, test.stored)
+		}
+		//The owner deletes the copies before it answers
+		for _, n := range nodes {
+			if holdsFile(n, key, test.fileName, hashes) {
+				t.Errorf("%s still holds %s or its content after the delete", n.Address, test.fileName)
+			}
+		}
+		if nodes[2].GetFile(test.fileName, filepath.Join(destination, "deleted")) {
+			t.Errorf("GetFile(%q) succeeded after the delete", test.fileName)
+		}
+		if nodes[2].DeleteFile(test.fileName) {
+			t.Errorf("DeleteFile(%q) succeeded a second time", test.fileName)
+		}
+	}
+}
+
+func TestLoadBucket(t *testing.T) {
+	storage := NewMemoryStorage()
+	n := &Node{storage: storage, Bucket: make(map[string][]string), Replicas: make(map[string][]string),
+		ReplicaOwner: make(map[string]string), Blobs: make(map[string]int)}
+	if n.loadBucket() {
+		t.Fatal("loadBucket found files in an empty storage")
+	}
+
+	blob := func(content string) string {
+		hash := checksum([]byte(content))
+		storage.WriteFile(n.blobPath(hash), []byte(content))
+		return hash
+	}
+	tests := []struct {
+		key      string
+		fileName string
+		contents []string //Content of every version, oldest first
+		missing  int      //How many of the oldest versions lost their content before the restart
+		kept     int      //Versions restored, 0 if the file is skipped
+	}{
+		{"100", "report.txt", []string{"report"}, 0, 1},
+		{"100", "dir/notes.txt", []string{"notes 1", "notes 2", "notes 3"}, 0, 3},
+		{"200", "partly.txt", []string{"partly 1", "partly 2"}, 1, 1},
+		{"300", "lost.txt", []string{"lost"}, 1, 0},
+		{"400", "same.txt", []string{"report"}, 0, 1}, //The content of report.txt, one blob for both
+	}
+	for _, test := range tests {
+		versions := make([]Version, 0, len(test.contents))
+		for i, content := range test.contents {
+			versions = append(versions, Version{Number: i + 1, Hash: blob(content), Size: int64(len(content))})
+		}
+		n.writeVersions(n.bucketDirectory(), test.key, test.fileName, versions)
+		for _, version := range versions[:test.missing] {
+			storage.Remove(n.blobPath(version.Hash))
+		}
+	}
+	//A copy of a file of another node and a directory that is not a key
+	copyHash := blob("copy")
+	n.writeVersions(n.replicaDirectory(), "500", "copy.txt", []Version{{Number: 1, Hash: copyHash}})
+	storage.WriteFile(n.bucketDirectory()+"/notakey/stray.txt", []byte("[]"))
+
+	if !n.loadBucket() {
+		t.Fatal("loadBucket found no files")
+	}
+	for _, test := range tests {
+		if restored := contains(n.Bucket[test.key], test.fileName); restored != (test.kept > 0) {
+			t.Errorf("%s restored = %t, want %t", test.fileName, restored, test.kept > 0)
+			continue
+		}
+		if test.kept == 0 {
+			continue
+		}
+		versions, err := n.readVersions(n.bucketDirectory(), test.key, test.fileName)
+		if err != nil || len(versions) != test.kept {
+			t.Errorf("%s has %d versions after loading (%v), want %d", test.fileName, len(versions), err, test.kept)
+		}
+		for _, version := range versions {
+			if !n.hasBlob(version.Hash) {
+				t.Errorf("%s kept version %d without its content", test.fileName, version.Number)
+			}
+		}
+	}
+	if references := n.Blobs[checksum([]byte("report"))]; references != 2 {
+		t.Errorf("the content of report.txt and same.txt has %d references, want 2", references)
+	}
+	if len(n.Bucket) != 3 || len(n.Replicas) != 0 {
+		t.Errorf("loadBucket restored the keys %v and the copies %v", n.Bucket, n.Replicas)
+	}
+	if copies, _ := storage.ReadDir(n.replicaDirectory()); len(copies) != 0 || n.hasBlob(copyHash) {
+		t.Errorf("the copy of another node's file and its content were kept: %v", copies)
+	}
+}
+
+/*
+nameWithKey returns the first of prefix-0, prefix-1, ... whose key on a ring of size m2 is in (from, to].
+*/
+func nameWithKey(prefix string, m2 big.Int, from int64, to int64) string {
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		if between(big.NewInt(from), hashModulo(Hash(name), m2), big.NewInt(to), true) {
+			return name
+		}
+	}
+}
+
+func TestRingRestartHandsBackKeys(t *testing.T) {
+	first := startPlacedRing(t, []int64{1000})[0]
+	flags := testFlags(t, first)
+	flags.UserID = userID(40000)
+	flags.Storage = "disk" //Kept over the restart
+	restarted := startNodeWith(t, flags)
+	waitFor(t, "a stable ring", func() bool { return ringStable([]*Node{first, restarted}) })
+
+	handed := nameWithKey("handed", first.M2, 1000, 30000)
+	kept := nameWithKey("kept", first.M2, 30000, 40000)
+	for _, key := range []string{handed, kept} {
+		if err := first.Put(key, []byte(key)); err != nil {
+			t.Fatalf("Put %s: %s", key, err)
+		}
+	}
+	handedKey, keptKey := hashModulo(Hash(handed), first.M2).String(), hashModulo(Hash(kept), first.M2).String()
+
+	//While the node at 40000 is down a node joins at 30000 and becomes responsible for the handed key
+	stopTestNode(restarted)
+	waitFor(t, "the first node alone", func() bool { return first.successor() == first.Address })
+	joinFlags := testFlags(t, first)
+	joinFlags.UserID = userID(30000)
+	joined := startNodeWith(t, joinFlags)
+	waitFor(t, "a stable ring with the node at 30000", func() bool { return ringStable([]*Node{first, joined}) })
+
+	//The node at 40000 restores both keys from disk and hands back the one it is no longer responsible for
+	restarted = startNodeWith(t, flags)
+	waitFor(t, "a stable ring after the restart", func() bool { return ringStable([]*Node{first, joined, restarted}) })
+	bucketHas := func(n *Node, key string) bool {
+		n.fileLock.Lock()
+		defer n.fileLock.Unlock()
+		return len(n.Bucket[key]) > 0
+	}
+	waitFor(t, "the handed key given back", func() bool { return !bucketHas(restarted, handedKey) })
+	if !bucketHas(restarted, keptKey) {
+		t.Errorf("the restarted node gave away %s, it is still responsible for it", kept)
+	}
+	if !bucketHas(joined, handedKey) {
+		t.Errorf("the node at 30000 does not have %s", handed)
+	}
+	for _, key := range []string{handed, kept} {
+		if !hasValue(first, key, []byte(key)) {
+			t.Errorf("%s was lost over the restart", key)
+		}
+	}
+}
+
+func TestRingGetFileDestination(t *testing.T) {
+	nodes := startRing(t, 2)
+	source := t.TempDir()
+	for _, fileName := range []string{"dir/sub/report.txt", "../escape.txt"} {
+		filePath := filepath.Join(source, "file")
+		if err := os.WriteFile(filePath, []byte(fileName), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !nodes[0].storeAs(filePath, fileName, 0) {
+			t.Fatalf("StoreFile %s failed", fileName)
+		}
+	}
+
+	//Into a directory, the directories of the filename are created below it
+	parent := t.TempDir()
+	destination := filepath.Join(parent, "downloads")
+	os.Mkdir(destination, 0755)
+	if !nodes[1].GetFile("dir/sub/report.txt", destination) {
+		t.Fatal("GetFile into a directory failed")
+	}
+	if got, err := os.ReadFile(filepath.Join(destination, "dir", "sub", "report.txt")); err != nil || string(got) != "dir/sub/report.txt" {
+		t.Errorf("GetFile into a directory saved %q, %v", got, err)
+	}
+
+	//A filename that leads out of the directory is refused there, and saved to an explicit destination
+	if nodes[1].GetFile("../escape.txt", destination) {
+		t.Error("GetFile saved ../escape.txt into a directory")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("../escape.txt was written outside the directory: %v", err)
+	}
+	destPath := filepath.Join(destination, "new", "escape.txt")
+	if !nodes[1].GetFile("../escape.txt", destPath) {
+		t.Fatal("GetFile to an explicit destination in a new directory failed")
+	}
+	if got, err := os.ReadFile(destPath); err != nil || string(got) != "../escape.txt" {
+		t.Errorf("GetFile to an explicit destination saved %q, %v", got, err)
+	}
+}
@@ -1,6 +1,9 @@
 package Chord
 
-import "math/big"
+import (
+	"math/big"
+	"time"
+)
 
 type SendArgs struct {
 	GetSuccessorRequest     bool
@@ -13,28 +16,82 @@ type SendArgs struct {
 	SendArg                 big.Int
 	SendArgString           string
 	Mrequest                bool
-	PutAllRequest           bool
-	SendBucket              map[string][]File
 	File                    File
 	GetIdentifier           bool
+	StoreChunkRequest       bool
+	ChunkOffsetRequest      bool
+	GetChunkRequest         bool
+	Chunk                   Chunk
+	LinkBlobRequest         bool
+	ListVersionsRequest     bool
+	DeleteFileRequest       bool
+	DeleteReplicaRequest    bool
+	DropReplicasRequest     bool //Drop the copies from the owner SendArgString, the node is no longer one of its first k successors
+	ListFilesRequest        bool
+	PutValueRequest         bool
+	GetValueRequest         bool
+	MerkleRequest           bool
+	Merkle                  MerkleQuery
+	ReconcileRequest        bool
+	Versions                []Version
+	CacheFileRequest        bool
+	InvalidateCacheRequest  bool
+	RecursiveLookupRequest  bool //Resolve SendArg, forwarding to the closest preceding node
+	Steps                   int  //Hops a recursive lookup may still take
 }
 type ReceiveArgs struct {
 	Answer              bool
+	Refused             bool //The request was not valid (e.g. a bad filename), sending it again does not help
 	ReplyArgs           string
 	FindSuccessorAnswer FindSuccessorAnswer
 	ReplyInt            int
+	RingID              string //Answer to an Mrequest: the ID of the ring, see datadir.go
 	SuccessorList       []string
-	SendBucket          map[string][]File
+	Chunk               Chunk
+	Offset              int64
+	Versions            []Version
+	Files               []FileInfo
+	File                File
+	MerkleHashes        []string
+	MerkleEntries       []MerkleEntry
+	Cached              bool          //Answer to a GetSuccessorRequest for a file: the node has the file cached
+	Path                []Hop         //The nodes a recursive lookup went through, from the node that was called
+	Forwarding          time.Duration //How long the node waited for the rest of a recursive lookup
+	NodeID              *big.Int      //ID of the node that answered, set on every reply
+	PeerIDs             []Peer        //IDs of the nodes the reply names
 }
 
 // Structs for different answers
 type FindSuccessorAnswer struct {
 	IsSuccessor bool
 	Address     string
+	Via         string  //Where the node that answered found Address: ViaSuccessor, ViaFinger or ViaSelf
+	ID          big.Int //Identifier of the node that answered
 }
 
 type File struct {
 	ID       big.Int
 	FileName string
 	Content  []byte
+	Checksum string //SHA-256 of Content computed by the sender, checked by the receiver
+	Uploader string
+}
+
+// A piece of a file that is sent between nodes. Size is the size of the whole file.
+type Chunk struct {
+	ID        big.Int
+	FileName  string
+	Offset    int64
+	Size      int64
+	Data      []byte
+	Hash      string    //SHA-256 of the whole file, lets the receiver skip content it already has
+	Version   int       //0 for a new upload, otherwise the version number on the sending node
+	Time      time.Time //When the version was uploaded
+	Uploader  string    //UserID of the node that uploaded the version
+	MimeType  string
+	Erasure   bool      //The file is the descriptor of an erasure coded file
+	Expires   time.Time //When the version is deleted, zero for never
+	Replica   bool      //Store as a copy, with the sender as primary owner
+	FromCache bool      //Read the file from the cache of the node instead of its bucket
+	ForCache  bool      //The file is fetched into the cache of the sender, which the owner tells when the file changes
 }
@@ -0,0 +1,117 @@
+package Chord
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+scrub is called periodically. Rehashes every blob the node stores and compares it with the hash it is stored under.
+A blob that is missing or does not match is reported, and repaired with a good copy from a neighbour that has
+the same content: the successors that keep our copies, the owners of the copies we keep, or the predecessor.
+*/
+func (n *Node) scrub(tsc int) {
+	duration := time.Duration(tsc) * time.Millisecond
+
+	for {
+		select {
+		case <-n.stopChan:
+			fmt.Println("Stopping scrubber")
+			return
+		default:
+			time.Sleep(duration)
+			if Debugging {
+				fmt.Printf("\nScrub\n")
+			}
+
+			n.fileLock.Lock()
+			hashes := make([]string, 0, len(n.Blobs))
+			for hash := range n.Blobs {
+				hashes = append(hashes, hash)
+			}
+			n.fileLock.Unlock()
+
+			for _, hash := range hashes {
+				actual, err := hashFile(n.storage, n.blobPath(hash))
+				if err == nil && actual == hash {
+					continue
+				}
+				if err != nil {
+					fmt.Printf("Scrubber: content %s is missing: %s\n", hash[:12], err)
+				} else {
+					fmt.Printf("Scrubber: content %s is corrupt, it hashes to %s\n", hash[:12], actual[:12])
+				}
+
+				repairedFrom, ok := n.repairBlob(hash)
+				switch {
+				case !ok:
+					fmt.Printf("Scrubber: no good copy of %s found, it stays corrupt\n", hash[:12])
+				case repairedFrom == "":
+					fmt.Printf("Scrubber: content %s is no longer used, it is not repaired\n", hash[:12])
+				default:
+					fmt.Printf("Scrubber: content %s repaired with the copy on %s\n", hash[:12], repairedFrom)
+				}
+			}
+		}
+	}
+}
+
+/*
+repairCandidates returns the nodes that may store the same content as this node.
+*/
+func (n *Node) repairCandidates() []string {
+	candidates := make([]string, 0)
+	add := func(address string) {
+		if address != "" && address != n.Address && !contains(candidates, address) {
+			candidates = append(candidates, address)
+		}
+	}
+
+	successors := n.successorList()
+	for _, successor := range n.replicaTargets(successors) {
+		add(successor)
+	}
+	n.fileLock.Lock()
+	for _, owner := range n.ReplicaOwner {
+		add(owner)
+	}
+	n.fileLock.Unlock()
+	add(n.predecessor())
+	for _, successor := range successors {
+		add(successor)
+	}
+	return candidates
+}
+
+/*
+repairBlob downloads the content with the given hash from the first neighbour that has an intact copy,
+and replaces the local blob with it. Returns the address it was repaired from. The last version using the
+content may be released during the download, then the blob is not written back and the address is empty.
+*/
+func (n *Node) repairBlob(hash string) (string, bool) {
+	repairPath := fmt.Sprintf("%s/%s/%s", n.partialDirectory(), "repair", hash)
+
+	for _, candidate := range n.repairCandidates() {
+		//fetchFile checks the downloaded content against the hash
+		_, err := n.fetchFile(candidate, Chunk{Hash: hash}, n.storage, repairPath)
+		if err != nil {
+			if Debugging {
+				fmt.Printf("repairBlob: %s could not help: %s\n", candidate, err)
+			}
+			continue
+		}
+
+		n.fileLock.Lock()
+		defer n.fileLock.Unlock()
+		if n.Blobs[hash] == 0 {
+			CheckError(n.storage.Remove(repairPath), "Remove in repairBlob")
+			return "", true
+		}
+		err = n.storage.Rename(repairPath, n.blobPath(hash))
+		if CheckError(err, "Rename in repairBlob") {
+			return "", false
+		}
+		return candidate, true
+	}
+	return "", false
+}
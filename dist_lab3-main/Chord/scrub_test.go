@@ -0,0 +1,58 @@
+package Chord
+
+import (
+	"testing"
+)
+
+/*
+valueHash returns the hash of the content n stores for the value under key, empty if it has none.
+*/
+func valueHash(n *Node, key string) string {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+	for _, directory := range []string{n.bucketDirectory(), n.replicaDirectory()} {
+		if version, err := n.findVersion(directory, hashModulo(Hash(key), n.M2).String(), valueName(key), 0); err == nil {
+			return version.Hash
+		}
+	}
+	return ""
+}
+
+func TestRingRepairBlob(t *testing.T) {
+	nodes := startRing(t, 2)
+	if err := nodes[0].Put("scrubbed", []byte("content to repair")); err != nil {
+		t.Fatal(err)
+	}
+	//With k = 2 both nodes keep the content, the owner in the bucket and the other node as a copy
+	for _, n := range nodes {
+		waitFor(t, "the content on "+n.Address, func() bool { return valueHash(n, "scrubbed") != "" })
+	}
+	n := nodes[0]
+	hash := valueHash(n, "scrubbed")
+
+	n.storage.WriteFile(n.blobPath(hash), []byte("rotten"))
+	repairedFrom, ok := n.repairBlob(hash)
+	if !ok || repairedFrom != nodes[1].Address {
+		t.Fatalf("repairBlob = %q, %t, want it repaired from %s", repairedFrom, ok, nodes[1].Address)
+	}
+	if actual, err := hashFile(n.storage, n.blobPath(hash)); err != nil || actual != hash {
+		t.Errorf("the repaired content hashes to %s (%v), want %s", actual, err, hash)
+	}
+
+	//Content that no version uses anymore is not written back
+	n.fileLock.Lock()
+	references := n.Blobs[hash]
+	delete(n.Blobs, hash)
+	n.storage.Remove(n.blobPath(hash))
+	n.fileLock.Unlock()
+	repairedFrom, ok = n.repairBlob(hash)
+	if !ok || repairedFrom != "" {
+		t.Errorf("repairBlob of released content = %q, %t, want it skipped", repairedFrom, ok)
+	}
+	if _, err := n.storage.Size(n.blobPath(hash)); err == nil {
+		t.Error("the released content was written back")
+	}
+	n.fileLock.Lock()
+	n.Blobs[hash] = references
+	n.fileLock.Unlock()
+}
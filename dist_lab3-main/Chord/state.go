@@ -0,0 +1,120 @@
+package Chord
+
+/*
+A node is used by several goroutines at once: the RPC server runs every incoming call in its own goroutine, next to
+stabilize, fix_fingers, check_predecessor, the scrubber, the expiry collector, anti-entropy and the input loop.
+Two locks guard the state they share:
+
+	ringLock - Predecessor, Successors and FingerTable
+	fileLock - Bucket, Replicas, ReplicaOwner, Blobs and the version lists in the storage
+
+Neither lock is held during a call to another node, the other node may call back (e.g. with a copy of a file that
+is being handed over). State is read under the lock, the call is made, and the result is applied under the lock.
+A function that takes fileLock may take ringLock while it holds it, never the other way round.
+Functions documented with "the caller holds n.fileLock" are called with the lock taken.
+*/
+
+/*
+successor returns the first entry of the successor list.
+*/
+func (n *Node) successor() string {
+	n.ringLock.RLock()
+	defer n.ringLock.RUnlock()
+	return n.Successors[0]
+}
+
+/*
+successorList returns a copy of the successor list.
+*/
+func (n *Node) successorList() []string {
+	n.ringLock.RLock()
+	defer n.ringLock.RUnlock()
+	successors := make([]string, len(n.Successors))
+	copy(successors, n.Successors)
+	return successors
+}
+
+/*
+setSuccessors replaces the successor list.
+*/
+func (n *Node) setSuccessors(successors []string) {
+	n.ringLock.Lock()
+	defer n.ringLock.Unlock()
+	n.Successors = successors
+}
+
+/*
+fingers returns a copy of the finger table.
+*/
+func (n *Node) fingers() []string {
+	n.ringLock.RLock()
+	defer n.ringLock.RUnlock()
+	fingers := make([]string, len(n.FingerTable))
+	copy(fingers, n.FingerTable)
+	return fingers
+}
+
+/*
+setFinger sets entry i of the finger table.
+*/
+func (n *Node) setFinger(i int, address string) {
+	n.ringLock.Lock()
+	defer n.ringLock.Unlock()
+	n.FingerTable[i] = address
+}
+
+/*
+forgetFinger clears the fingers that point at address, after a lookup could not reach it. fix_fingers refills them.
+*/
+func (n *Node) forgetFinger(address string) {
+	n.ringLock.Lock()
+	defer n.ringLock.Unlock()
+	for i, finger := range n.FingerTable {
+		if finger == address && address != n.Address {
+			n.FingerTable[i] = ""
+		}
+	}
+}
+
+/*
+predecessor returns the current predecessor, empty if it is not known.
+*/
+func (n *Node) predecessor() string {
+	n.ringLock.RLock()
+	defer n.ringLock.RUnlock()
+	return n.Predecessor
+}
+
+/*
+replacePredecessor sets the predecessor to address if it is still old. Returns false if another goroutine changed
+it in between, e.g. notify while check_predecessor waited for the old predecessor to answer.
+*/
+func (n *Node) replacePredecessor(old string, address string) bool {
+	n.ringLock.Lock()
+	defer n.ringLock.Unlock()
+	if n.Predecessor != old {
+		return false
+	}
+	n.Predecessor = address
+	return true
+}
+
+/*
+bucketFiles returns a copy of the bucket, key -> filenames.
+*/
+func (n *Node) bucketFiles() map[string][]string {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+	return copyFiles(n.Bucket)
+}
+
+/*
+copyFiles returns a copy of a map of key -> filenames, which can be used without holding n.fileLock.
+*/
+func copyFiles(files map[string][]string) map[string][]string {
+	copied := make(map[string][]string, len(files))
+	for key, fileNames := range files {
+		copied[key] = append([]string(nil), fileNames...)
+	}
+	return copied
+}
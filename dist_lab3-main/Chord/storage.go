@@ -0,0 +1,340 @@
+package Chord
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Storage is where a node keeps its bucket, replicas, blobs and partial uploads. Names are slash separated
+paths like bucket/<key>/<filename>; writing a name creates its directories. Missing names give an error
+for which os.IsNotExist is true. Choose the implementation with --storage:
+
+	disk   - one file per name under the node's data directory (default)
+	memory - everything in memory, lost when the node stops. For tests and ephemeral nodes
+	file   - one embedded file store.db in the node's data directory holding all names (see filestorage.go)
+
+Files of the user, like the file given to StoreFile or the destination of GetFile, are not part of the storage.
+*/
+type Storage interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, content []byte) error
+	AppendFile(name string, data []byte) (int64, error) //Returns the size after the append
+	ReadAt(name string, buffer []byte, offset int64) (int, error)
+	Size(name string) (int64, error)
+	Rename(oldName string, newName string) error
+	Remove(name string) error
+	RemoveAll(name string) error           //Removes name and everything below it
+	ReadDir(name string) ([]string, error) //Names directly below name, sorted
+}
+
+// The storage kinds accepted by --storage
+var StorageKinds = []string{"disk", "memory", "file"}
+
+// localDisk reads and writes the files of the user, relative to the current directory.
+var localDisk Storage = DiskStorage{}
+
+/*
+openStorage creates the storage of the kind given with --storage for the node.
+*/
+func (n *Node) openStorage(kind string) (Storage, error) {
+	switch kind {
+	case "disk":
+		return DiskStorage{Root: n.dataDirectory}, nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "file":
+		return OpenFileStorage(filepath.Join(n.dataDirectory, "store.db"))
+	}
+	return nil, fmt.Errorf("unknown storage %s, use one of %s", kind, strings.Join(StorageKinds, ", "))
+}
+
+/*
+bucketDirectory is the storage directory of the files the node is responsible for.
+*/
+func (n *Node) bucketDirectory() string {
+	return "bucket"
+}
+
+/*
+partialDirectory is the storage directory where uploads are collected until they are complete.
+*/
+func (n *Node) partialDirectory() string {
+	return "partial"
+}
+
+/*
+localPartialDirectory is the directory on the local disk for files that are prepared or collected outside the
+storage, like encrypted uploads and fragments. It is the partial directory in the node's data directory.
+*/
+func (n *Node) localPartialDirectory() string {
+	return filepath.Join(n.dataDirectory, n.partialDirectory())
+}
+
+/*
+storageReader reads a stored name from the start, for io.Copy and friends.
+*/
+type storageReader struct {
+	storage Storage
+	name    string
+	offset  int64
+}
+
+func (r *storageReader) Read(buffer []byte) (int, error) {
+	read, err := r.storage.ReadAt(r.name, buffer, r.offset)
+	r.offset += int64(read)
+	if err == io.EOF && read > 0 {
+		err = nil
+	}
+	return read, err
+}
+
+/*
+notExist is the error for a name that is not stored.
+*/
+func notExist(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+/*
+childNames returns the sorted names directly below dir among the stored names.
+*/
+func childNames(names []string, dir string) []string {
+	prefix := path.Clean(dir) + "/"
+	unique := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		child := strings.SplitN(name[len(prefix):], "/", 2)[0]
+		unique[child] = true
+	}
+	children := make([]string, 0, len(unique))
+	for child := range unique {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children
+}
+
+/*
+isBelow returns true if name is dir itself or inside it.
+*/
+func isBelow(name string, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+/*
+DiskStorage keeps every name as a file under Root, the current directory if Root is empty.
+*/
+type DiskStorage struct {
+	Root string
+}
+
+func (d DiskStorage) path(name string) string {
+	return filepath.Join(d.Root, filepath.FromSlash(name))
+}
+
+func (d DiskStorage) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(name))
+}
+
+func (d DiskStorage) WriteFile(name string, content []byte) error {
+	err := os.MkdirAll(filepath.Dir(d.path(name)), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.path(name), content, 0644)
+}
+
+func (d DiskStorage) AppendFile(name string, data []byte) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(d.path(name)), os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(d.path(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (d DiskStorage) ReadAt(name string, buffer []byte, offset int64) (int, error) {
+	file, err := os.Open(d.path(name))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.ReadAt(buffer, offset)
+}
+
+func (d DiskStorage) Size(name string) (int64, error) {
+	info, err := os.Stat(d.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (d DiskStorage) Rename(oldName string, newName string) error {
+	err := os.MkdirAll(filepath.Dir(d.path(newName)), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(d.path(oldName), d.path(newName))
+}
+
+func (d DiskStorage) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d DiskStorage) RemoveAll(name string) error {
+	return os.RemoveAll(d.path(name))
+}
+
+func (d DiskStorage) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+/*
+MemoryStorage keeps every name in a map. Nothing survives the node, so a restarted node starts empty.
+*/
+type MemoryStorage struct {
+	mutex sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+func (m *MemoryStorage) names() []string {
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m *MemoryStorage) ReadFile(name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	content, ok := m.files[path.Clean(name)]
+	if !ok {
+		return nil, notExist("read", name)
+	}
+	return append([]byte(nil), content...), nil
+}
+
+func (m *MemoryStorage) WriteFile(name string, content []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files[path.Clean(name)] = append([]byte(nil), content...)
+	return nil
+}
+
+func (m *MemoryStorage) AppendFile(name string, data []byte) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = path.Clean(name)
+	m.files[name] = append(m.files[name], data...)
+	return int64(len(m.files[name])), nil
+}
+
+func (m *MemoryStorage) ReadAt(name string, buffer []byte, offset int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	content, ok := m.files[path.Clean(name)]
+	if !ok {
+		return 0, notExist("read", name)
+	}
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	read := copy(buffer, content[offset:])
+	if read < len(buffer) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (m *MemoryStorage) Size(name string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	content, ok := m.files[path.Clean(name)]
+	if !ok {
+		return 0, notExist("stat", name)
+	}
+	return int64(len(content)), nil
+}
+
+func (m *MemoryStorage) Rename(oldName string, newName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	content, ok := m.files[path.Clean(oldName)]
+	if !ok {
+		return notExist("rename", oldName)
+	}
+	delete(m.files, path.Clean(oldName))
+	m.files[path.Clean(newName)] = content
+	return nil
+}
+
+func (m *MemoryStorage) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = path.Clean(name)
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if len(childNames(m.names(), name)) > 0 {
+		return fmt.Errorf("remove %s: directory not empty", name)
+	}
+	return nil //Like an empty directory
+}
+
+func (m *MemoryStorage) RemoveAll(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = path.Clean(name)
+	for stored := range m.files {
+		if isBelow(stored, name) {
+			delete(m.files, stored)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) ReadDir(name string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	children := childNames(m.names(), name)
+	if len(children) == 0 {
+		return nil, notExist("readdir", name)
+	}
+	return children, nil
+}
@@ -0,0 +1,135 @@
+package Chord
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+storageKinds opens an empty storage of every kind, in directories of the test.
+*/
+func storageKinds(t *testing.T) map[string]Storage {
+	file, err := OpenFileStorage(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.file.Close() })
+	return map[string]Storage{
+		"disk":   DiskStorage{Root: t.TempDir()},
+		"memory": NewMemoryStorage(),
+		"file":   file,
+	}
+}
+
+/*
+TestStorageContract runs the same operations against every Storage and checks they behave alike.
+*/
+func TestStorageContract(t *testing.T) {
+	for kind, storage := range storageKinds(t) {
+		t.Run(kind, func(t *testing.T) {
+			if _, err := storage.ReadFile("bucket/missing"); !os.IsNotExist(err) {
+				t.Errorf("ReadFile of a missing name: %v", err)
+			}
+			if _, err := storage.Size("bucket/missing"); !os.IsNotExist(err) {
+				t.Errorf("Size of a missing name: %v", err)
+			}
+			if _, err := storage.ReadDir("bucket"); !os.IsNotExist(err) {
+				t.Errorf("ReadDir of a missing directory: %v", err)
+			}
+
+			//Write creates the directories and replaces the content
+			if err := storage.WriteFile("bucket/1/a.txt", []byte("first")); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.WriteFile("bucket/1/a.txt", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if content, err := storage.ReadFile("bucket/1/a.txt"); err != nil || string(content) != "hello" {
+				t.Errorf("ReadFile = %q, %v", content, err)
+			}
+
+			//Append creates the name and returns the size after it
+			for i, part := range []string{"abc", "", "defg"} {
+				size, err := storage.AppendFile("partial/b", []byte(part))
+				if want := []int64{3, 3, 7}[i]; err != nil || size != want {
+					t.Errorf("AppendFile %d = %d, %v, want %d", i, size, err, want)
+				}
+			}
+			if size, err := storage.Size("partial/b"); err != nil || size != 7 {
+				t.Errorf("Size = %d, %v", size, err)
+			}
+
+			//ReadAt across the appended parts, and io.EOF with what was read at the end
+			buffer := make([]byte, 4)
+			if read, err := storage.ReadAt("partial/b", buffer, 1); err != nil || string(buffer[:read]) != "bcde" {
+				t.Errorf("ReadAt middle = %q, %v", buffer[:read], err)
+			}
+			if read, err := storage.ReadAt("partial/b", buffer, 5); err != io.EOF || string(buffer[:read]) != "fg" {
+				t.Errorf("ReadAt end = %q, %v", buffer[:read], err)
+			}
+			if read, err := storage.ReadAt("partial/b", buffer, 7); err != io.EOF || read != 0 {
+				t.Errorf("ReadAt past the end = %d, %v", read, err)
+			}
+			if content, err := io.ReadAll(&storageReader{storage: storage, name: "partial/b"}); err != nil || string(content) != "abcdefg" {
+				t.Errorf("storageReader = %q, %v", content, err)
+			}
+
+			//Rename into a directory that does not exist yet
+			if err := storage.Rename("partial/b", "bucket/2/b"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.ReadFile("partial/b"); !os.IsNotExist(err) {
+				t.Errorf("the old name is still there after Rename: %v", err)
+			}
+			if content, err := storage.ReadFile("bucket/2/b"); err != nil || string(content) != "abcdefg" {
+				t.Errorf("ReadFile after Rename = %q, %v", content, err)
+			}
+			if err := storage.Rename("partial/missing", "bucket/3"); !os.IsNotExist(err) {
+				t.Errorf("Rename of a missing name: %v", err)
+			}
+
+			//ReadDir gives the names directly below, sorted
+			storage.WriteFile("bucket/c", []byte{})
+			if names, err := storage.ReadDir("bucket"); err != nil || !reflect.DeepEqual(names, []string{"1", "2", "c"}) {
+				t.Errorf("ReadDir = %v, %v", names, err)
+			}
+			if size, err := storage.Size("bucket/c"); err != nil || size != 0 {
+				t.Errorf("Size of an empty name = %d, %v", size, err)
+			}
+
+			//Remove takes one name, not a directory with names in it
+			if err := storage.Remove("bucket/1"); err == nil {
+				t.Error("Remove of a directory that is not empty")
+			}
+			if err := storage.Remove("bucket/1/a.txt"); err != nil {
+				t.Error(err)
+			}
+			if _, err := storage.ReadFile("bucket/1/a.txt"); !os.IsNotExist(err) {
+				t.Errorf("ReadFile after Remove: %v", err)
+			}
+
+			//RemoveAll takes everything below
+			if err := storage.RemoveAll("bucket"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.ReadDir("bucket"); !os.IsNotExist(err) {
+				t.Errorf("ReadDir after RemoveAll: %v", err)
+			}
+			if _, err := storage.ReadFile("bucket/2/b"); !os.IsNotExist(err) {
+				t.Errorf("ReadFile after RemoveAll: %v", err)
+			}
+
+			//Content is copied, changing the slice later does not change what is stored
+			content := []byte("copy")
+			storage.WriteFile("bucket/d", content)
+			content[0] = 'X'
+			if stored, _ := storage.ReadFile("bucket/d"); !bytes.Equal(stored, []byte("copy")) {
+				t.Errorf("stored content changed with the caller's slice: %q", stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package Chord
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+/*
+Trace, Takes a filename or an ID on the ring and a lookup mode (empty for --lookup). Looks up the node responsible
+for it and prints every hop: its address and ID, the node it answered with, whether it found that node in its finger
+table or successor list, and the round trip of the call to it. The hops up to a failure are printed too.
+*/
+func (n *Node) Trace(target string, mode string) (Route, error) {
+	key := n.traceKey(target)
+	route, err := n.LookupRoute(key, mode)
+
+	fmt.Printf("Trace of %s (key %s), %s lookup:\n", target, key.String(), route.Mode)
+	for i, hop := range route.Hops {
+		fmt.Printf("  %2d  %-21s  ID %-6s  %-9s -> %-21s  %s\n", i+1, hop.Address, hop.ID.String(), hop.Via, hop.Answer, hop.RTT.Round(time.Microsecond))
+	}
+	if err != nil {
+		fmt.Printf("Trace failed after %d hops: %s\n", len(route.Hops), err)
+		return route, err
+	}
+	fmt.Printf("Stored at %s, %d hops in %s\n", route.Owner, len(route.Hops), route.Elapsed.Round(time.Microsecond))
+	return route, nil
+}
+
+/*
+traceKey returns the key Trace looks up: target itself if it is a decimal number on the ring, otherwise the key of
+the filename target.
+*/
+func (n *Node) traceKey(target string) big.Int {
+	id, ok := new(big.Int).SetString(target, 10)
+	if ok && n.checkKey(id) == nil {
+		return *id
+	}
+	return *hashModulo(Hash(target), n.M2)
+}
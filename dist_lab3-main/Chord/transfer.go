@@ -0,0 +1,355 @@
+package Chord
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// Files are sent between nodes in chunks of ChunkSize bytes, so no side has to keep a whole file in memory.
+const ChunkSize = 1 << 20
+
+// Number of times a transfer resumes after a failed call before it gives up.
+const MaxRetries = 5
+
+const RetryDelay = 500 * time.Millisecond
+
+/*
+partialPath is where the chunks of a file are collected until the whole file is received.
+Uploads to the bucket and to the replicas are kept apart, a node can receive both for the same key.
+*/
+func (n *Node) partialPath(chunk Chunk) string {
+	kind := "bucket"
+	if chunk.Replica {
+		kind = "replica"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s@%d", n.partialDirectory(), kind, chunk.ID.String(), escapeName(chunk.FileName), chunk.Version)
+}
+
+/*
+fileSize returns the size of the stored name, or 0 if it does not exist.
+*/
+func fileSize(storage Storage, name string) int64 {
+	size, err := storage.Size(name)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+/*
+sendFile streams the file name in storage to the node on address, one chunk per call. The template chunk gives the
+key, filename and whether the receiver stores it as a copy. Before sending it asks the receiver how much of the
+file it already has, so an interrupted transfer continues where it stopped. Returns true when the receiver has the whole file.
+*/
+func (n *Node) sendFile(address string, template Chunk, storage Storage, name string) bool {
+	size, err := storage.Size(name)
+	if CheckError(err, "Size in sendFile") {
+		fmt.Printf("No such file on disk in sendFile: %s\n", name)
+		return false
+	}
+	template.Size = size
+
+	if template.Hash != "" && n.linkBlob(address, template) {
+		return true //The receiver already has the content, only the name was added
+	}
+
+	offset, ok := n.chunkOffset(address, template)
+	retries := 0
+	buffer := make([]byte, ChunkSize)
+
+	for {
+		if !ok {
+			retries++
+			if retries > MaxRetries {
+				fmt.Printf("Giving up sending %s to %s after %d retries\n", template.FileName, address, MaxRetries)
+				return false
+			}
+			time.Sleep(RetryDelay)
+			offset, ok = n.chunkOffset(address, template)
+			continue
+		}
+
+		read, err := storage.ReadAt(name, buffer, offset)
+		if err != nil && err != io.EOF {
+			CheckError(err, "ReadAt in sendFile")
+			return false
+		}
+		if read == 0 && offset < template.Size {
+			fmt.Printf("%s changed on disk while it was sent\n", name)
+			return false
+		}
+
+		chunk := template
+		chunk.Offset = offset
+		chunk.Data = buffer[:read]
+
+		SenderArgs := SendArgs{StoreChunkRequest: true, Chunk: chunk, SendArgString: n.Address}
+		ReceiveArgs := ReceiveArgs{}
+		ok = n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address)
+		if !ok {
+			continue
+		}
+		if ReceiveArgs.Refused {
+			fmt.Printf("%s did not accept %s: %s\n", address, template.FileName, ReceiveArgs.ReplyArgs)
+			return false
+		}
+		if !ReceiveArgs.Answer {
+			if Debugging {
+				fmt.Printf("sendFile: %s, resuming at offset %d\n", ReceiveArgs.ReplyArgs, ReceiveArgs.Offset)
+			}
+			retries++ //e.g. the content did not match its checksum and has to be sent again
+			if retries > MaxRetries {
+				fmt.Printf("Giving up sending %s to %s: %s\n", template.FileName, address, ReceiveArgs.ReplyArgs)
+				return false
+			}
+		}
+		offset = ReceiveArgs.Offset //The receiver tells us where to continue, also when it rejected the chunk
+
+		if ReceiveArgs.Answer && offset >= template.Size {
+			return true
+		}
+	}
+}
+
+/*
+linkBlob asks the node on address to add the file in chunk using content it already stores with the same hash.
+Returns false if the receiver does not have the content, then it has to be sent.
+*/
+func (n *Node) linkBlob(address string, chunk Chunk) bool {
+	SenderArgs := SendArgs{LinkBlobRequest: true, Chunk: chunk, SendArgString: n.Address}
+	ReceiveArgs := ReceiveArgs{}
+	ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address)
+	return ok && ReceiveArgs.Answer
+}
+
+/*
+chunkOffset asks the node on address how many bytes of the file it has received so far.
+*/
+func (n *Node) chunkOffset(address string, chunk Chunk) (int64, bool) {
+	SenderArgs := SendArgs{ChunkOffsetRequest: true, Chunk: chunk}
+	ReceiveArgs := ReceiveArgs{}
+	ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address)
+	return ReceiveArgs.Offset, ok
+}
+
+/*
+receivedOffset returns how many bytes of the file in chunk have been received. A partial file that is larger
+than the announced size belongs to another upload and is removed.
+*/
+func (n *Node) receivedOffset(chunk Chunk) int64 {
+	partial := n.partialPath(chunk)
+	size := fileSize(n.storage, partial)
+	if size > chunk.Size {
+		CheckError(n.storage.Remove(partial), "Remove in receivedOffset")
+		return 0
+	}
+	return size
+}
+
+/*
+storeChunk appends a received chunk to the partial file. The chunk must start where the partial file ends,
+otherwise it is rejected and the sender continues from the returned offset. When the last chunk has arrived the
+file is moved into the bucket (or among the replicas, with origin as primary owner).
+Returns the number of bytes received so far.
+*/
+func (n *Node) storeChunk(chunk Chunk, origin string) (int64, error) {
+	current := n.receivedOffset(chunk)
+	if chunk.Offset != current {
+		return current, fmt.Errorf("chunk for %s starts at %d, expected %d", chunk.FileName, chunk.Offset, current)
+	}
+
+	size, err := n.storage.AppendFile(n.partialPath(chunk), chunk.Data)
+	if CheckError(err, "AppendFile in storeChunk") {
+		return n.receivedOffset(chunk), err
+	}
+	current = size
+
+	if current == chunk.Size {
+		return current, n.finishFile(chunk, origin)
+	}
+	return current, nil
+}
+
+/*
+finishFile moves a completely received file from its partial path into the blob directory and lets the
+filename point at it. If the sender gave a hash, content that does not match it is thrown away.
+*/
+func (n *Node) finishFile(chunk Chunk, origin string) error {
+	partial := n.partialPath(chunk)
+	defer n.storage.Remove(path.Dir(partial)) //Only removed if no other upload for the key is in progress
+
+	if chunk.Hash != "" {
+		hash, err := hashFile(n.storage, partial)
+		if err == nil && hash != chunk.Hash {
+			n.storage.Remove(partial)
+			return fmt.Errorf("content of %s does not match its hash, the file has to be sent again", chunk.FileName)
+		}
+	}
+
+	n.fileLock.Lock()
+	hash, err := n.storeBlob(partial)
+	if CheckError(err, "storeBlob in finishFile") {
+		n.fileLock.Unlock()
+		return err
+	}
+	err = n.linkVersion(chunk, hash, origin)
+	n.fileLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if !chunk.Replica {
+		n.addFile(chunk.ID.String(), chunk.FileName)
+	}
+	return nil
+}
+
+/*
+linkFile adds the content with hash chunk.Hash, which the node already stores, as a version of the filename in chunk.
+Returns an error if the node does not have the content, then the sender sends it.
+*/
+func (n *Node) linkFile(chunk Chunk, origin string) error {
+	n.fileLock.Lock()
+	if !n.hasBlob(chunk.Hash) {
+		n.fileLock.Unlock()
+		return fmt.Errorf("node %s does not have content %s", n.Address, chunk.Hash)
+	}
+	err := n.linkVersion(chunk, chunk.Hash, origin)
+	n.fileLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if !chunk.Replica {
+		n.addFile(chunk.ID.String(), chunk.FileName)
+	}
+	return nil
+}
+
+/*
+linkVersion adds the blob with the given hash as a version of the filename in chunk, in the bucket or among the
+replicas. Copies are registered with origin as primary owner, the caller adds files in the bucket with addFile.
+Chunks without a version number are new uploads and get the next version. The caller holds n.fileLock.
+*/
+func (n *Node) linkVersion(chunk Chunk, hash string, origin string) error {
+	key := chunk.ID.String()
+
+	BucketDirectory := n.bucketDirectory()
+	if chunk.Replica {
+		BucketDirectory = n.replicaDirectory()
+	}
+	version := Version{Number: chunk.Version, Time: chunk.Time, Hash: hash, Size: chunk.Size, Uploader: chunk.Uploader, MimeType: chunk.MimeType, Erasure: chunk.Erasure, Expires: chunk.Expires}
+	_, err := n.addVersion(BucketDirectory, key, chunk.FileName, version)
+	if CheckError(err, "addVersion in linkVersion") {
+		return err
+	}
+
+	if chunk.Replica {
+		n.addReplica(key, chunk.FileName, origin)
+	}
+	return nil
+}
+
+/*
+getChunk reads the chunk of a stored file that starts at chunk.Offset. The returned chunk has the total size
+of the file so the caller knows when it is done, and its hash so the caller can check the download.
+*/
+func (n *Node) getChunk(chunk Chunk) (Chunk, error) {
+	if chunk.FromCache {
+		return n.cachedChunk(chunk)
+	}
+	if chunk.FileName == "" && chunk.Hash != "" {
+		//Content asked for by its hash, by a neighbour repairing its copy
+		if !n.hasBlob(chunk.Hash) {
+			return chunk, fmt.Errorf("node %s does not have content %s", n.Address, chunk.Hash)
+		}
+	} else {
+		key := chunk.ID.String()
+		n.fileLock.Lock()
+		if !contains(n.Bucket[key], chunk.FileName) {
+			n.fileLock.Unlock()
+			return chunk, fmt.Errorf("no file named %s in the bucket of node %s (key %s)", chunk.FileName, n.Address, key)
+		}
+
+		version, err := n.findVersion(n.bucketDirectory(), key, chunk.FileName, chunk.Version)
+		n.fileLock.Unlock()
+		if err != nil {
+			return chunk, err
+		}
+		chunk.Hash = version.Hash
+	}
+
+	size, err := n.storage.Size(n.blobPath(chunk.Hash))
+	if err != nil {
+		return chunk, fmt.Errorf("file %s is in the bucket of node %s but could not be read from disk", chunk.FileName, n.Address)
+	}
+
+	buffer := make([]byte, ChunkSize)
+	read, err := n.storage.ReadAt(n.blobPath(chunk.Hash), buffer, chunk.Offset)
+	if err != nil && err != io.EOF {
+		return chunk, err
+	}
+	chunk.Size = size
+	chunk.Data = buffer[:read]
+	return chunk, nil
+}
+
+/*
+fetchFile downloads the file (and version, 0 for the latest) in request from the node on address into destPath
+in storage. A request with only a hash downloads that content. Chunks are collected in destPath.part, if that file exists
+from an earlier interrupted download the transfer continues after its last byte. The finished download is
+checked against the hash the sender has for it.
+*/
+func (n *Node) fetchFile(address string, request Chunk, storage Storage, destPath string) (int64, error) {
+	partial := destPath + ".part"
+	offset := fileSize(storage, partial)
+
+	retries := 0
+	for {
+		request.Offset = offset
+		SenderArgs := SendArgs{GetChunkRequest: true, Chunk: request, SendArgString: n.Address}
+		ReceiveArgs := ReceiveArgs{}
+		ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address)
+		if !ok {
+			retries++
+			if retries > MaxRetries {
+				return offset, fmt.Errorf("lost connection to %s after %d bytes, run GetFile again to resume", address, offset)
+			}
+			time.Sleep(RetryDelay)
+			continue
+		}
+		if !ReceiveArgs.Answer {
+			if offset == 0 {
+				storage.Remove(partial)
+			}
+			return offset, fmt.Errorf("%s", ReceiveArgs.ReplyArgs)
+		}
+
+		chunk := ReceiveArgs.Chunk
+		if len(chunk.Data) == 0 && offset < chunk.Size {
+			return offset, fmt.Errorf("%s changed on %s during the download", request.FileName, address)
+		}
+		if offset > chunk.Size { //Left over from another file with the same name
+			storage.Remove(partial)
+			return n.fetchFile(address, request, storage, destPath)
+		}
+		size, err := storage.AppendFile(partial, chunk.Data)
+		if err != nil {
+			return offset, err
+		}
+		offset = size
+
+		if offset >= chunk.Size {
+			if chunk.Hash != "" {
+				hash, err := hashFile(storage, partial)
+				if err != nil || hash != chunk.Hash {
+					storage.Remove(partial)
+					return offset, fmt.Errorf("downloaded content from %s does not match its checksum, try again", address)
+				}
+			}
+			return offset, storage.Rename(partial, destPath)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package Chord
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+/*
+Small values can be stored under a key with Put and read back with Get. A value is placed like a file with
+the key as filename, on the successor of hashModulo(Hash(key), n.M2), and is kept in the bucket under the name
+#value:<key>, so it cannot collide with a file with the same name. Values are versioned, copied to the
+successors and handed over on join and Exit like files. They are not encrypted with --key.
+*/
+
+const valuePrefix = "#value:"
+
+// The largest value Put accepts, it is sent in one call.
+const MaxValueSize = ChunkSize
+
+/*
+valueName returns the name a value is kept under in the bucket.
+*/
+func valueName(key string) string {
+	return valuePrefix + key
+}
+
+/*
+isValueName returns true if the name in the bucket belongs to a value stored with Put.
+*/
+func isValueName(fileName string) bool {
+	return strings.HasPrefix(fileName, valuePrefix)
+}
+
+/*
+Put stores value under key in the ring, replacing the value the key had as a new version.
+*/
+func (n *Node) Put(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("the key is empty")
+	}
+	if err := checkName(valueName(key)); err != nil {
+		return err
+	}
+	if len(value) > MaxValueSize {
+		return fmt.Errorf("the value is %d bytes, at most %d bytes can be stored with Put, use StoreFile", len(value), MaxValueSize)
+	}
+	found, owner := n.locate(key)
+	if !found {
+		return fmt.Errorf("no node found for %s", key)
+	}
+
+	KeyID := hashModulo(Hash(key), n.M2)
+	SenderArgs := SendArgs{PutValueRequest: true, File: File{ID: *KeyID, FileName: key, Content: value, Checksum: checksum(value), Uploader: n.uploaderID()}}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, owner) {
+		return fmt.Errorf("could not reach %s", owner)
+	}
+	if !ReceiveArgs.Answer {
+		return fmt.Errorf("%s", ReceiveArgs.ReplyArgs)
+	}
+	return nil
+}
+
+/*
+Get returns the value stored under key in the ring.
+*/
+func (n *Node) Get(key string) ([]byte, error) {
+	found, owner := n.locate(key)
+	if !found {
+		return nil, fmt.Errorf("no node found for %s", key)
+	}
+
+	KeyID := hashModulo(Hash(key), n.M2)
+	SenderArgs := SendArgs{GetValueRequest: true, File: File{ID: *KeyID, FileName: key}}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, owner) {
+		return nil, fmt.Errorf("could not reach %s", owner)
+	}
+	if !ReceiveArgs.Answer {
+		return nil, fmt.Errorf("%s", ReceiveArgs.ReplyArgs)
+	}
+	value := ReceiveArgs.File.Content
+	if checksum(value) != ReceiveArgs.File.Checksum {
+		return nil, fmt.Errorf("the value of %s from %s does not match its checksum", key, owner)
+	}
+	return value, nil
+}
+
+/*
+putValue stores a value sent with Put in the bucket.
+*/
+func (n *Node) putValue(file File) error {
+	if len(file.Content) > MaxValueSize {
+		return fmt.Errorf("the value is larger than %d bytes", MaxValueSize)
+	}
+	file.FileName = valueName(file.FileName)
+	return n.putFile(file)
+}
+
+/*
+getValue returns the latest version of the value under the key in file.
+*/
+func (n *Node) getValue(file File) ([]byte, error) {
+	key, name := file.ID.String(), valueName(file.FileName)
+	n.fileLock.Lock()
+	if !contains(n.Bucket[key], name) {
+		n.fileLock.Unlock()
+		return nil, fmt.Errorf("no value stored under %s on node %s", file.FileName, n.Address)
+	}
+	version, err := n.findVersion(n.bucketDirectory(), key, name, 0)
+	n.fileLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return n.storage.ReadFile(n.blobPath(version.Hash))
+}
+
+/*
+Client lets a program that is not a node use a running ring. Every operation starts its lookup at the ring node
+it was created with, so that node has to be up for every call. When it is not, Dial another node of the ring.
+
+	client, err := Chord.Dial("127.0.0.1:1111")
+	err = client.Put("config", []byte("value"))
+	value, err := client.Get("config")
+*/
+type Client struct {
+	node *Node
+}
+
+/*
+Dial creates a client that enters the ring at the node on address ("ip:port").
+*/
+func Dial(address string) (*Client, error) {
+	n := &Node{Address: address, peerIDs: make(map[string]big.Int), latency: newLatencies()}
+	SenderArgs := SendArgs{Mrequest: true}
+	ReceiveArgs := ReceiveArgs{}
+	if !n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, address) {
+		return nil, fmt.Errorf("could not reach the ring node %s", address)
+	}
+	n.M = ReceiveArgs.ReplyInt
+	n.M2 = *n.calculateM2()
+
+	hostname, _ := os.Hostname()
+	n.Flags.UserID = "client@" + hostname
+	return &Client{node: n}, nil
+}
+
+// Put stores value under key in the ring.
+func (c *Client) Put(key string, value []byte) error {
+	return c.node.Put(key, value)
+}
+
+// Get returns the value stored under key in the ring.
+func (c *Client) Get(key string) ([]byte, error) {
+	return c.node.Get(key)
+}
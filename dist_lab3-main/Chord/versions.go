@@ -0,0 +1,251 @@
+package Chord
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One stored version of a file and its metadata. The content is the blob with the given hash.
+type Version struct {
+	Number   int
+	Time     time.Time //When the version was uploaded
+	Hash     string
+	Size     int64
+	Uploader string //UserID (-i) of the node that stored it
+	MimeType string
+	Erasure  bool      //The content is the descriptor of an erasure coded file
+	Expires  time.Time //When the version is deleted, zero if it is kept until it is replaced or deleted
+}
+
+/*
+versionsPath returns the path of the file listing the versions of a filename under key in BucketDirectory.
+*/
+func versionsPath(BucketDirectory string, key string, fileName string) string {
+	return fmt.Sprintf("%s/%s/%s", BucketDirectory, key, escapeName(fileName))
+}
+
+/*
+readVersions returns the versions of a stored filename, oldest first.
+*/
+func (n *Node) readVersions(BucketDirectory string, key string, fileName string) ([]Version, error) {
+	content, err := n.storage.ReadFile(versionsPath(BucketDirectory, key, fileName))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0)
+	err = json.Unmarshal(content, &versions)
+	if err == nil && len(versions) == 0 {
+		err = fmt.Errorf("%s has no versions", fileName)
+	}
+	return versions, err
+}
+
+/*
+writeVersions saves the list of versions of a filename.
+*/
+func (n *Node) writeVersions(BucketDirectory string, key string, fileName string, versions []Version) error {
+	content, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	return n.storage.WriteFile(versionsPath(BucketDirectory, key, fileName), content)
+}
+
+/*
+addVersion adds a version pointing at the blob with hash version.Hash to a filename.
+A version with Number 0 is a new upload: it gets the next number and the current time, unless the
+content is the same as in the latest version. Versions with a number, sent during handoff or replication,
+are added as they are if the file does not have them yet.
+If the version is there already only its expiry time is updated, a file can be stored again with another TTL.
+Only the newest --versions versions are kept. Returns the version that the file has now.
+The caller holds n.fileLock.
+*/
+func (n *Node) addVersion(BucketDirectory string, key string, fileName string, version Version) (Version, error) {
+	versions, err := n.readVersions(BucketDirectory, key, fileName)
+	if err != nil {
+		versions = make([]Version, 0)
+	}
+
+	if version.Number == 0 {
+		if len(versions) > 0 {
+			latest := versions[len(versions)-1]
+			if latest.Hash == version.Hash {
+				//Same content uploaded again
+				return n.updateExpiry(BucketDirectory, key, fileName, versions, len(versions)-1, version.Expires)
+			}
+			version.Number = latest.Number + 1
+		} else {
+			version.Number = 1
+		}
+		version.Time = time.Now()
+	} else {
+		for i, existing := range versions {
+			if existing.Number == version.Number {
+				//Already have it
+				return n.updateExpiry(BucketDirectory, key, fileName, versions, i, version.Expires)
+			}
+		}
+	}
+
+	versions = append(versions, version)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+
+	//Drop the oldest versions above the limit
+	removed := make([]Version, 0)
+	for len(versions) > n.Flags.Versions {
+		removed = append(removed, versions[0])
+		versions = versions[1:]
+	}
+
+	err = n.writeVersions(BucketDirectory, key, fileName, versions)
+	if err != nil {
+		return version, err
+	}
+
+	n.Blobs[version.Hash]++
+	for _, old := range removed {
+		n.unrefBlob(old.Hash)
+	}
+	return version, nil
+}
+
+/*
+updateExpiry sets the expiry time of versions[i] and saves the versions if it changed. Returns the version.
+*/
+func (n *Node) updateExpiry(BucketDirectory string, key string, fileName string, versions []Version, i int, expires time.Time) (Version, error) {
+	if versions[i].Expires.Equal(expires) {
+		return versions[i], nil
+	}
+	versions[i].Expires = expires
+	return versions[i], n.writeVersions(BucketDirectory, key, fileName, versions)
+}
+
+/*
+removeVersions deletes all versions of a filename and releases their blobs. The caller holds n.fileLock.
+*/
+func (n *Node) removeVersions(BucketDirectory string, key string, fileName string) {
+	versions, err := n.readVersions(BucketDirectory, key, fileName)
+	if CheckError(err, "readVersions in removeVersions") {
+		return
+	}
+	CheckError(n.storage.Remove(versionsPath(BucketDirectory, key, fileName)), "Remove in removeVersions")
+	for _, version := range versions {
+		n.unrefBlob(version.Hash)
+	}
+}
+
+/*
+findVersion returns the version with the given number of a stored filename, or the latest version if number is 0.
+*/
+func (n *Node) findVersion(BucketDirectory string, key string, fileName string, number int) (Version, error) {
+	versions, err := n.readVersions(BucketDirectory, key, fileName)
+	if err != nil {
+		return Version{}, err
+	}
+	if number == 0 {
+		number = versions[len(versions)-1].Number
+	}
+	for _, version := range versions {
+		if version.Number == number {
+			if version.expired(time.Now()) {
+				return Version{}, fmt.Errorf("%s@%d has expired", fileName, number)
+			}
+			return version, nil
+		}
+	}
+	return Version{}, fmt.Errorf("%s has no version %d (versions kept: %d to %d)", fileName, number, versions[0].Number, versions[len(versions)-1].Number)
+}
+
+/*
+splitVersion splits "name@version" into the filename and the version number.
+A name without a numeric @version suffix gives version 0, which means the latest version.
+*/
+func splitVersion(nameWithVersion string) (string, int) {
+	at := strings.LastIndex(nameWithVersion, "@")
+	if at < 0 {
+		return nameWithVersion, 0
+	}
+	number, err := strconv.Atoi(nameWithVersion[at+1:])
+	if err != nil || number < 1 {
+		return nameWithVersion, 0
+	}
+	return nameWithVersion[:at], number
+}
+
+/*
+sendVersions streams every version of a stored filename to the node on address. Content the receiver
+already has is not sent again. Returns true if the receiver got all versions.
+*/
+func (n *Node) sendVersions(address string, BucketDirectory string, key string, fileName string, replica bool) bool {
+	n.fileLock.Lock()
+	versions, err := n.readVersions(BucketDirectory, key, fileName)
+	n.fileLock.Unlock()
+	if CheckError(err, "readVersions in sendVersions") {
+		fmt.Printf("No such file on disk: %s\n", fileName)
+		return false
+	}
+
+	Key := new(big.Int)
+	Key.SetString(key, 10)
+
+	for _, version := range versions {
+		if !n.sendFile(address, versionChunk(*Key, fileName, version, replica), n.storage, n.blobPath(version.Hash)) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+versionChunk returns the chunk template that sends a stored version with its number and metadata.
+*/
+func versionChunk(Key big.Int, fileName string, version Version, replica bool) Chunk {
+	return Chunk{ID: Key, FileName: fileName, Hash: version.Hash, Version: version.Number, Time: version.Time,
+		Uploader: version.Uploader, MimeType: version.MimeType, Erasure: version.Erasure, Expires: version.Expires, Replica: replica}
+}
+
+/*
+ListVersions, Takes a filename. Runs func Lookup on the filename and prints the versions the responsible node keeps.
+*/
+func (n *Node) ListVersions(fileName string) []Version {
+
+	FileID, fileOwner := n.Lookup(fileName)
+
+	SenderArgs := SendArgs{ListVersionsRequest: true, File: File{ID: FileID, FileName: fileName}}
+	ReceiveArgs := ReceiveArgs{}
+	ok := n.call("Node.CallHandler", &SenderArgs, &ReceiveArgs, fileOwner)
+
+	if !ok {
+		fmt.Printf("Error during call in ListVersions\n")
+		return nil
+	}
+	if !ReceiveArgs.Answer {
+		fmt.Printf("ListVersions failed: %s\n", ReceiveArgs.ReplyArgs)
+		return nil
+	}
+
+	fmt.Printf("Versions of %s stored at %s:\n", fileName, fileOwner)
+	for _, version := range ReceiveArgs.Versions {
+		fmt.Printf("  %s@%d  %s  %10d bytes  %s  %s%s\n", fileName, version.Number, version.Time.Format("2006-01-02 15:04:05"),
+			version.Size, version.Hash[:12], version.Uploader, version.expiryText())
+	}
+	return ReceiveArgs.Versions
+}
+
+/*
+listVersions returns the versions of a file in the node's bucket.
+*/
+func (n *Node) listVersions(key string, fileName string) ([]Version, error) {
+	n.fileLock.Lock()
+	defer n.fileLock.Unlock()
+
+	if !contains(n.Bucket[key], fileName) {
+		return nil, fmt.Errorf("no file named %s in the bucket of node %s (key %s)", fileName, n.Address, key)
+	}
+	return n.readVersions(n.bucketDirectory(), key, fileName)
+}
@@ -0,0 +1,106 @@
+package Chord
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		name    string
+		version int
+	}{
+		{"report", "report", 0},
+		{"report@2", "report", 2},
+		{"report@12", "report", 12},
+		{"a@b@3", "a@b", 3},
+		{"user@host", "user@host", 0},
+		{"report@", "report@", 0},
+		{"report@0", "report@0", 0},
+		{"report@-1", "report@-1", 0},
+		{"dir/report@4", "dir/report", 4},
+	}
+	for _, test := range tests {
+		name, version := splitVersion(test.input)
+		if name != test.name || version != test.version {
+			t.Errorf("splitVersion(%q) = %q, %d, want %q, %d", test.input, name, version, test.name, test.version)
+		}
+	}
+}
+
+/*
+TestVersionNamesAreUnambiguous checks that a name that splitVersion would split cannot be stored.
+*/
+func TestVersionNamesAreUnambiguous(t *testing.T) {
+	for _, fileName := range []string{"report@2", "report@0", "a@b@3", "dir/report@4"} {
+		if err := checkName(fileName); err == nil {
+			t.Errorf("checkName accepted %q, which GetFile reads as a version", fileName)
+		}
+	}
+	for _, fileName := range []string{"report", "user@host", "report@", "report@-1", "report@2.txt", "v@1a"} {
+		if err := checkName(fileName); err != nil {
+			t.Errorf("checkName(%q): %s", fileName, err)
+		}
+		if name, version := splitVersion(fileName); name != fileName || version != 0 {
+			t.Errorf("splitVersion(%q) = %q, %d", fileName, name, version)
+		}
+	}
+}
+
+func TestAddVersion(t *testing.T) {
+	n := &Node{storage: NewMemoryStorage(), Blobs: make(map[string]int)}
+	n.Flags.Versions = 3
+	hash := func(c string) string {
+		return strings.Repeat(c, 64)
+	}
+	add := func(version Version) Version {
+		added, err := n.addVersion("bucket", "7", "f", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+	numbers := func() []int {
+		versions, err := n.readVersions("bucket", "7", "f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		list := make([]int, 0, len(versions))
+		for _, version := range versions {
+			list = append(list, version.Number)
+		}
+		return list
+	}
+
+	if got := add(Version{Hash: hash("a")}); got.Number != 1 || got.Time.IsZero() {
+		t.Errorf("first upload: %+v", got)
+	}
+	if got := add(Version{Hash: hash("b")}); got.Number != 2 {
+		t.Errorf("second upload got number %d", got.Number)
+	}
+
+	//The same content again keeps the version and only takes the new expiry time
+	expires := time.Now().Add(time.Hour).Round(0)
+	if got := add(Version{Hash: hash("b"), Expires: expires}); got.Number != 2 || !got.Expires.Equal(expires) {
+		t.Errorf("same content again: %+v", got)
+	}
+	if n.Blobs[hash("b")] != 1 {
+		t.Errorf("blob b has %d references after storing it twice", n.Blobs[hash("b")])
+	}
+
+	//A numbered version from replication is added as it is, in order, and only once
+	add(Version{Number: 5, Hash: hash("c")})
+	add(Version{Number: 4, Hash: hash("d")})
+	add(Version{Number: 4, Hash: hash("d")})
+	if got := numbers(); len(got) != 3 || got[0] != 2 || got[1] != 4 || got[2] != 5 {
+		t.Errorf("versions %v, want the newest three 2, 4, 5", got)
+	}
+	if _, ok := n.Blobs[hash("a")]; ok {
+		t.Error("the blob of the dropped version 1 is still referenced")
+	}
+	if got := add(Version{Hash: hash("e")}); got.Number != 6 {
+		t.Errorf("upload after version 5 got number %d", got.Number)
+	}
+}